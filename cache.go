@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lvoytek/discourse_client_go/pkg/discourse"
+)
+
+// Increment when the layout of DiscourseCache changes so stale state files are ignored
+const cacheFileVersion = 2
+
+type cacheFile struct {
+	Version int `json:"version"`
+	DiscourseCache
+}
+
+// Load previously collected Discourse data from a state file into the cache
+func LoadCache(path string) error {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("No cache found at", path, "- starting with an empty cache")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cache read error: %v", err)
+	}
+
+	var loadedCache cacheFile
+	err = json.Unmarshal(data, &loadedCache)
+
+	if err != nil {
+		return fmt.Errorf("cache parse error: %v", err)
+	}
+
+	if loadedCache.Version != cacheFileVersion {
+		log.Println("Cache at", path, "uses format version", loadedCache.Version, "instead of", cacheFileVersion, "- starting with an empty cache")
+		return nil
+	}
+
+	if loadedCache.Topics == nil {
+		loadedCache.Topics = make(map[string]map[int]*discourse.TopicData)
+	}
+
+	if loadedCache.Users == nil {
+		loadedCache.Users = make(map[string]*discourse.TopicParticipant)
+	}
+
+	if loadedCache.TopicEdits == nil {
+		loadedCache.TopicEdits = make(map[int]map[int]map[int]*discourse.PostRevision)
+	}
+
+	// State files from before listings were marked as crawled are crawled in full once
+	if loadedCache.CrawledListings == nil {
+		loadedCache.CrawledListings = make(map[string]bool)
+	}
+
+	cacheWriteMutex.Lock()
+	defer cacheWriteMutex.Unlock()
+	cache = loadedCache.DiscourseCache
+
+	return nil
+}
+
+// Atomically write the current cache to a state file for use in the next run
+func SaveCache(path string) error {
+	cacheWriteMutex.Lock()
+	data, err := json.Marshal(cacheFile{
+		Version:        cacheFileVersion,
+		DiscourseCache: cache,
+	})
+	cacheWriteMutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("cache encode error: %v", err)
+	}
+
+	// Write to a temporary file in the same directory, then swap it in place
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return fmt.Errorf("cache write error: %v", err)
+	}
+
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(data)
+
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	closeErr := tempFile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("cache write error: %v", err)
+	}
+
+	err = os.Rename(tempFile.Name(), path)
+
+	if err != nil {
+		return fmt.Errorf("cache write error: %v", err)
+	}
+
+	return nil
+}
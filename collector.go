@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,213 +18,640 @@ import (
 )
 
 type DiscourseCache struct {
+	Categories []discourse.Category
+
 	// Topics mapped by category slug and topic ID
-	Topics     map[string]map[int]*discourse.TopicData
-	Users      map[string]*discourse.TopicParticipant
-	TopicEdits map[int]map[int]*discourse.PostRevision
+	Topics map[string]map[int]*discourse.TopicData
+	Users  map[string]*discourse.TopicParticipant
+	// Post revisions mapped by topic ID, post ID, and revision number
+	TopicEdits map[int]map[int]map[int]*discourse.PostRevision
+	// Category and tag listings paged through to the end with every listed topic downloaded, so later collections
+	// can stop at the first unchanged topic
+	CrawledListings map[string]bool
 }
 
 // Cache data used to avoid unnecessary Discourse API calls
 var (
 	cache = DiscourseCache{
-		Topics:     make(map[string]map[int]*discourse.TopicData),
-		Users:      make(map[string]*discourse.TopicParticipant),
-		TopicEdits: make(map[int]map[int]*discourse.PostRevision),
+		Topics:          make(map[string]map[int]*discourse.TopicData),
+		Users:           make(map[string]*discourse.TopicParticipant),
+		TopicEdits:      make(map[int]map[int]map[int]*discourse.PostRevision),
+		CrawledListings: make(map[string]bool),
 	}
-	cacheWriteMutex   sync.Mutex
-	rateLimitMutex    sync.Mutex
-	rateLimitDuration time.Duration = time.Second
+	cacheWriteMutex sync.Mutex
+)
+
+// Settings for how the collector calls the Discourse site
+type CollectorConfig struct {
+	// Number of categories, topics, or topic edit histories to collect at once
+	Concurrency  int
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// A topic to download along with the category it is stored under
+type categoryTopic struct {
+	categorySlug string
+	topicID      int
+}
+
+// Topics found in a category or tag listing that need downloading
+type topicListing struct {
+	name   string
+	topics []categoryTopic
+	// Whether the listing was paged through to the end, or to where it was last crawled to
+	complete bool
+}
+
+// Retry settings for calls to the Discourse site that fail before getting an HTTP response, set at the start of each collection
+var (
+	callRetries      int
+	callRetryBackoff time.Duration
 )
 
-func Collect(discourseClient *discourse.Client, itemsToExport ItemsToExport, rateLimit time.Duration) DiscourseCache {
-	var collectorWg sync.WaitGroup
-	rateLimitDuration = rateLimit
+// Items that could not be collected in the current collection
+var (
+	failedItems      []string
+	failedItemsMutex sync.Mutex
+)
+
+// Number of posts to request at once when loading the rest of a topic's post stream
+const topicPostBatchSize = 20
+
+// Update the cache from the Discourse site, stopping between API calls once the context is cancelled.
+// Returns an error listing the items that could not be collected, along with everything that was.
+func Collect(ctx context.Context, discourseClient *discourse.Client, itemsToExport ItemsToExport, config CollectorConfig) (DiscourseCache, error) {
+	callRetries = config.Retries
+	callRetryBackoff = config.RetryBackoff
 
-	categoryList := []string{itemsToExport.LimitToCategorySlug}
+	failedItemsMutex.Lock()
+	failedItems = []string{}
+	failedItemsMutex.Unlock()
 
-	// Get all categories if no category or topic specified
-	if itemsToExport.LimitToCategorySlug == "" && itemsToExport.LimitToTopicID == 0 {
-		allCategories, err := discourse.ListCategories(discourseClient, true)
-		rateLimitDelay()
+	collectByTag := len(itemsToExport.LimitToTags) > 0
+	collectAllCategories := len(itemsToExport.LimitToCategorySlugs) == 0 && len(itemsToExport.LimitToTopicIDs) == 0 && !collectByTag
+
+	var allCategories *discourse.ListCategoriesResponse
+
+	// Get all categories if they are exported or crawled, or to find the category slugs of topics and exclusions
+	if itemsToExport.Categories || collectAllCategories || len(itemsToExport.LimitToTopicIDs) > 0 || collectByTag || len(itemsToExport.ExcludeCategorySlugs) > 0 {
+		var err error
+		allCategories, err = withRetries(ctx, func() (*discourse.ListCategoriesResponse, error) {
+			return discourse.ListCategories(discourseClient, true)
+		})
 
 		if err != nil {
-			log.Fatalln("Unable to list categories -", err)
+			recordFailure(ctx, "category list")
+			return cache, fmt.Errorf("unable to list categories: %v", err)
 		}
 
-		categoryList = []string{}
+		cacheWriteMutex.Lock()
+		cache.Categories = allCategories.CategoryList.Categories
+		cacheWriteMutex.Unlock()
+	}
 
-		for _, nextCategory := range allCategories.CategoryList.Categories {
-			categoryList = append(categoryList, nextCategory.Slug)
+	var categories []discourse.Category
 
-			for _, nextSubcategory := range nextCategory.SubcategoryList {
-				categoryList = append(categoryList, nextCategory.Slug+"/"+nextSubcategory.Slug)
+	if allCategories != nil {
+		categories = allCategories.CategoryList.Categories
+	}
+
+	// Categories to crawl, tags are crawled instead when given
+	categoryList := crawledCategories(categories, itemsToExport)
+
+	// Topics, Topic Comments, and Topic Users
+	if itemsToExport.Topics || itemsToExport.TopicComments || itemsToExport.TopicEdits {
+		// Find updated topics in every category or tag, then share the topic downloads between all workers
+		var topicsToFetchMutex sync.Mutex
+		topicsToFetch := []categoryTopic{}
+		queuedTopics := map[categoryTopic]bool{}
+		queuedTopicIDs := map[int]bool{}
+		fetchedTopics := map[categoryTopic]bool{}
+		listings := []topicListing{}
+
+		queueTopics := func(listing topicListing) {
+			topicsToFetchMutex.Lock()
+			defer topicsToFetchMutex.Unlock()
+
+			listings = append(listings, listing)
+
+			// A topic can be listed under several of the chosen tags, each storing it under its own category. Parent and
+			// subcategory listings each keep their own copy, so topics stay under the category listing they were found in.
+			for _, topic := range listing.topics {
+				queuedTopicIDs[topic.topicID] = true
+
+				if !queuedTopics[topic] {
+					queuedTopics[topic] = true
+					topicsToFetch = append(topicsToFetch, topic)
+				}
 			}
 		}
-	}
 
-	// Topic Comments and Topic Users
-	if itemsToExport.TopicComments || itemsToExport.TopicEdits {
-		if itemsToExport.LimitToTopicID > 0 {
-			collectTopicAndAssociatedUsers(discourseClient, itemsToExport.LimitToTopicID)
+		if collectByTag {
+			runWorkerPool(config.Concurrency, itemsToExport.LimitToTags, func(tag string) {
+				queueTopics(listUpdatedTopicsWithTag(ctx, discourseClient, tag, itemsToExport))
+			})
 		} else {
-			for _, categorySlug := range categoryList {
-				collectorWg.Add(1)
-				go collectTopicsAndUsersFromCategory(&collectorWg, discourseClient, categorySlug)
+			runWorkerPool(config.Concurrency, categoryList, func(categorySlug string) {
+				queueTopics(listUpdatedTopicsInCategory(ctx, discourseClient, categorySlug, itemsToExport))
+			})
+		}
+
+		runWorkerPool(config.Concurrency, topicsToFetch, func(topic categoryTopic) {
+			if collectCategoryTopicAndUsers(ctx, discourseClient, topic.categorySlug, topic.topicID, itemsToExport) {
+				topicsToFetchMutex.Lock()
+				fetchedTopics[topic] = true
+				topicsToFetchMutex.Unlock()
 			}
+		})
 
-			collectorWg.Wait()
-		}
+		updateCrawledListings(ctx, listings, fetchedTopics)
+
+		// Chosen topics are always downloaded, unless already found in a chosen category or tag
+		chosenTopicIDs := slices.DeleteFunc(slices.Clone(itemsToExport.LimitToTopicIDs), func(topicID int) bool {
+			return queuedTopicIDs[topicID]
+		})
+
+		runWorkerPool(config.Concurrency, chosenTopicIDs, func(topicID int) {
+			collectTopicAndAssociatedUsers(ctx, discourseClient, topicID, itemsToExport)
+		})
 	}
 
 	// Topic Edits
 	if itemsToExport.TopicEdits {
-		if itemsToExport.LimitToTopicID > 0 {
-			// Find single topic to export in cache
-			var topicData *discourse.TopicData
-			var ok bool
-
-			for _, topics := range cache.Topics {
-				topicData, ok = topics[itemsToExport.LimitToTopicID]
+		topicsToCheck := map[int]*discourse.TopicData{}
+		topicIDsToCheck := []int{}
 
-				if ok {
-					break
+		cacheWriteMutex.Lock()
+		for categorySlug, topics := range cache.Topics {
+			for topicID, topic := range topics {
+				if topicInScope(categorySlug, topicID, topic, categoryList, itemsToExport) {
+					topicsToCheck[topicID] = topic
+					topicIDsToCheck = append(topicIDsToCheck, topicID)
 				}
 			}
+		}
+		cacheWriteMutex.Unlock()
 
-			if ok {
-				collectTopicEditsFromTopic(discourseClient, itemsToExport.LimitToTopicID, topicData)
-			} else {
-				log.Println("Unable to find topic", itemsToExport.LimitToTopicID, "in cache")
+		for _, topicID := range itemsToExport.LimitToTopicIDs {
+			if _, ok := topicsToCheck[topicID]; !ok {
+				log.Println("Unable to find topic", topicID, "in cache")
 			}
+		}
+
+		runWorkerPool(config.Concurrency, topicIDsToCheck, func(topicID int) {
+			collectTopicEditsFromTopic(ctx, discourseClient, topicID, topicsToCheck[topicID], itemsToExport)
+		})
+	}
+
+	failedItemsMutex.Lock()
+	defer failedItemsMutex.Unlock()
+
+	if ctx.Err() == nil && len(failedItems) == 0 {
+		lastCollectionTimestamp.SetToCurrentTime()
+	}
+
+	if len(failedItems) > 0 {
+		log.Println("Unable to collect", len(failedItems), "items:")
+
+		for _, item := range failedItems {
+			log.Println(" -", item)
+		}
+
+		return cache, fmt.Errorf("%d items could not be collected", len(failedItems))
+	}
+
+	return cache, nil
+}
+
+// Mark listings as crawled once they were paged through and all their topics downloaded. Others are unmarked, as
+// topics older than where they stopped may be missing from the cache.
+func updateCrawledListings(ctx context.Context, listings []topicListing, fetchedTopics map[categoryTopic]bool) {
+	cacheWriteMutex.Lock()
+	defer cacheWriteMutex.Unlock()
+
+	for _, listing := range listings {
+		crawled := listing.complete && ctx.Err() == nil && !slices.ContainsFunc(listing.topics, func(topic categoryTopic) bool {
+			return !fetchedTopics[topic]
+		})
+
+		if crawled {
+			cache.CrawledListings[listing.name] = true
 		} else {
-			for _, categorySlug := range categoryList {
-				collectorWg.Add(1)
-				go collectTopicEditsFromCacheTopicList(&collectorWg, discourseClient, categorySlug)
-			}
+			delete(cache.CrawledListings, listing.name)
+		}
+	}
+}
+
+// Find the topics in a category that need downloading
+func listUpdatedTopicsInCategory(ctx context.Context, discourseClient *discourse.Client, categorySlug string, itemsToExport ItemsToExport) topicListing {
+	listingName := "category " + categorySlug
+
+	// Work on a copy so the cache is only read while locked
+	cacheWriteMutex.Lock()
+	cachedTopics := maps.Clone(cache.Topics[categorySlug])
+	categories := cache.Categories
+	listingCrawled := cache.CrawledListings[listingName]
+	cacheWriteMutex.Unlock()
+
+	updatedTopics, complete := listUpdatedTopics(ctx, "category", categorySlug, cachedTopics, listingCrawled, itemsToExport, func(page int) ([]discourse.SuggestedTopic, error) {
+		categoryData, err := discourse.GetCategoryContentsBySlug(discourseClient, categorySlug, page)
 
-			collectorWg.Wait()
+		if err != nil {
+			return nil, err
 		}
+
+		return categoryData.TopicList.Topics, nil
+	})
+
+	topicsToFetch := []categoryTopic{}
+
+	for _, topicOverview := range updatedTopics {
+		// Parent category listings include the topics of excluded subcategories
+		if topicCategorySlug, ok := categorySlugByID(categories, topicOverview.CategoryID); ok && categoryWithinAny(topicCategorySlug, itemsToExport.ExcludeCategorySlugs) {
+			continue
+		}
+
+		topicsToFetch = append(topicsToFetch, categoryTopic{categorySlug: categorySlug, topicID: topicOverview.ID})
 	}
 
-	return cache
+	return topicListing{name: listingName, topics: topicsToFetch, complete: complete}
 }
 
-func collectTopicsAndUsersFromCategory(wg *sync.WaitGroup, discourseClient *discourse.Client, categorySlug string) {
-	defer wg.Done()
-	topics, ok := cache.Topics[categorySlug]
+// Find the topics with a tag that need downloading, stored under the slug of the category each is in
+func listUpdatedTopicsWithTag(ctx context.Context, discourseClient *discourse.Client, tag string, itemsToExport ItemsToExport) topicListing {
+	listingName := "tag " + tag
+
+	// Tagged topics can be in any category, so compare against every cached topic
+	cachedTopics := map[int]*discourse.TopicData{}
+
+	cacheWriteMutex.Lock()
+	for _, topics := range cache.Topics {
+		maps.Copy(cachedTopics, topics)
+	}
+
+	categories := cache.Categories
+	listingCrawled := cache.CrawledListings[listingName]
+	cacheWriteMutex.Unlock()
+
+	updatedTopics, complete := listUpdatedTopics(ctx, "tag", tag, cachedTopics, listingCrawled, itemsToExport, func(page int) ([]discourse.SuggestedTopic, error) {
+		return getTagTopicsPage(discourseClient, tag, page)
+	})
 
-	if !ok {
-		topics = map[int]*discourse.TopicData{}
+	topicsToFetch := []categoryTopic{}
+
+	for _, topicOverview := range updatedTopics {
+		categorySlug, ok := categorySlugByID(categories, topicOverview.CategoryID)
+
+		if !ok {
+			log.Println("Could not find category", topicOverview.CategoryID, "for topic", topicOverview.ID, "with tag", tag)
+			continue
+		}
+
+		// Only keep topics within the chosen categories and their subcategories
+		if len(itemsToExport.LimitToCategorySlugs) > 0 && !categoryWithinAny(categorySlug, itemsToExport.LimitToCategorySlugs) {
+			continue
+		}
+
+		if categoryWithinAny(categorySlug, itemsToExport.ExcludeCategorySlugs) {
+			continue
+		}
+
+		topicsToFetch = append(topicsToFetch, categoryTopic{categorySlug: categorySlug, topicID: topicOverview.ID})
 	}
 
-	// Check each page of topics for category until there are no new topic bumps
+	return topicListing{name: listingName, topics: topicsToFetch, complete: complete}
+}
+
+// Page through a topic listing, returning the topics that need downloading and whether the listing was fully checked.
+// Once a listing has been crawled to the end, paging stops at the first topic not bumped since the last collection.
+func listUpdatedTopics(ctx context.Context, listType string, listName string, cachedTopics map[int]*discourse.TopicData, listingCrawled bool, itemsToExport ItemsToExport, getPage func(page int) ([]discourse.SuggestedTopic, error)) ([]discourse.SuggestedTopic, bool) {
 	page := 0
 	newTopics := []discourse.SuggestedTopic{}
-	for {
-		categoryData, err := discourse.GetCategoryContentsBySlug(discourseClient, categorySlug, page)
-		rateLimitDelay()
+	complete := false
+
+	for ctx.Err() == nil {
+		pageTopics, err := withRetries(ctx, func() ([]discourse.SuggestedTopic, error) {
+			return getPage(page)
+		})
 
+		// Keep the topics found on earlier pages
 		if err != nil {
-			log.Println("Category data collection error for", categorySlug, "on page", page, "-", err)
-			return
+			recordFailure(ctx, fmt.Sprintf("%s %s page %d", listType, listName, page))
+			log.Println("Topic list collection error for", listType, listName, "on page", page, "-", err)
+			break
 		}
 
-		if len(categoryData.TopicList.Topics) == 0 {
+		if len(pageTopics) == 0 {
+			complete = true
 			break
 		}
 
-		newTopics = append(newTopics, categoryData.TopicList.Topics...)
+		newTopics = append(newTopics, pageTopics...)
 
-		// Check if final topic on this page has not been updated since last check
-		cachedCompareTopic, ok := topics[newTopics[len(newTopics)-1].ID]
+		// Topics are listed by latest activity, so later pages are all older than the export window
+		if !itemsToExport.Since.IsZero() && topicLastActivity(newTopics[len(newTopics)-1]).Before(itemsToExport.Since) {
+			break
+		}
+
+		// Check if final topic on this page has not been updated since last check, every older topic is then cached
+		cachedCompareTopic, ok := cachedTopics[newTopics[len(newTopics)-1].ID]
 
-		if ok && cachedCompareTopic.LastPostedAt.Compare(newTopics[len(newTopics)-1].LastPostedAt) >= 0 {
+		if listingCrawled && ok && cachedCompareTopic.LastPostedAt.Compare(newTopics[len(newTopics)-1].LastPostedAt) >= 0 {
+			complete = true
 			break
 		}
 
 		page++
 	}
 
-	additionalUsers := map[string]*discourse.TopicParticipant{}
+	updatedTopics := []discourse.SuggestedTopic{}
 
 	for _, topicOverview := range newTopics {
-		cachedTopic, topicExists := topics[topicOverview.ID]
+		if !itemsToExport.TopicInTimeWindow(topicOverview.CreatedAt, topicLastActivity(topicOverview)) {
+			continue
+		}
+
+		cachedTopic, topicExists := cachedTopics[topicOverview.ID]
 
 		// If cached topic data exists, check if it actually needs to be updated
-		if topicExists && cachedTopic.LastPostedAt.Compare(topicOverview.LastPostedAt) >= 0 {
+		if topicExists && cachedTopic.LastPostedAt.Compare(topicOverview.LastPostedAt) >= 0 && !cachedTopicIncomplete(cachedTopic, itemsToExport) {
 			continue
 		}
 
-		// Get a new copy of the topic
-		updatedTopic, err := discourse.GetTopicByID(discourseClient, topicOverview.ID)
-		rateLimitDelay()
+		updatedTopics = append(updatedTopics, topicOverview)
+	}
 
-		if err == nil {
-			topics[topicOverview.ID] = updatedTopic
+	return updatedTopics, complete
+}
 
-			additionalTopicUsers := getUsersListedInTopic(discourseClient, updatedTopic)
+func getTagTopicsPage(discourseClient *discourse.Client, tag string, page int) ([]discourse.SuggestedTopic, error) {
+	data, err := discourseClient.GetWithQueryString("tag/"+url.PathEscape(tag), fmt.Sprintf("page=%d", page))
 
-			for k, v := range additionalTopicUsers {
-				additionalUsers[k] = v
-			}
+	if err != nil {
+		return nil, err
+	}
 
-		} else {
+	var tagData discourse.TagData
+	err = json.Unmarshal(data, &tagData)
+	return tagData.TopicList.Topics, err
+}
+
+// Get the time a listed topic was last bumped or posted in
+func topicLastActivity(topic discourse.SuggestedTopic) time.Time {
+	if topic.BumpedAt.After(topic.LastPostedAt) {
+		return topic.BumpedAt
+	}
+
+	return topic.LastPostedAt
+}
+
+// Download a topic and its users into the cache, returning whether it was stored
+func collectCategoryTopicAndUsers(ctx context.Context, discourseClient *discourse.Client, categorySlug string, topicID int, itemsToExport ItemsToExport) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	updatedTopic, err := withRetries(ctx, func() (*discourse.TopicData, error) {
+		return getTopicByID(ctx, discourseClient, topicID, itemsToExport)
+	})
+
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			recordFailure(ctx, fmt.Sprintf("topic %d", topicID))
 			log.Println("Download topic error:", err)
 		}
+
+		return false
 	}
 
-	if len(topics) > 0 {
-		cacheWriteMutex.Lock()
-		defer cacheWriteMutex.Unlock()
-		cache.Topics[categorySlug] = topics
+	additionalUsers := getUsersListedInTopic(ctx, discourseClient, updatedTopic)
+	storeTopicAndUsers(categorySlug, topicID, updatedTopic, additionalUsers)
+	return true
+}
+
+func collectTopicAndAssociatedUsers(ctx context.Context, discourseClient *discourse.Client, topicID int, itemsToExport ItemsToExport) {
+	updatedTopic, err := withRetries(ctx, func() (*discourse.TopicData, error) {
+		return getTopicByID(ctx, discourseClient, topicID, itemsToExport)
+	})
+
+	if err == nil {
+		additionalUsers := getUsersListedInTopic(ctx, discourseClient, updatedTopic)
+
+		categoryName, ok := categorySlugByID(cache.Categories, updatedTopic.CategoryID)
 
-		// Add newly found users
-		for username, additionalUser := range additionalUsers {
-			_, userExists := cache.Users[username]
+		if !ok {
+			categoryData, err := withRetries(ctx, func() (*discourse.ShowCategoryResponse, error) {
+				return discourse.ShowCategory(discourseClient, updatedTopic.CategoryID)
+			})
 
-			if !userExists {
-				cache.Users[username] = additionalUser
+			if err != nil {
+				recordFailure(ctx, fmt.Sprintf("category %d", updatedTopic.CategoryID))
+				log.Println("Could not find category for topic ", updatedTopic.Title, "-", err)
+			} else {
+				categoryName = categoryData.Category.Slug
 			}
 		}
+
+		storeTopicAndUsers(categoryName, topicID, updatedTopic, additionalUsers)
+	} else if !errors.Is(err, context.Canceled) {
+		recordFailure(ctx, fmt.Sprintf("topic %d", topicID))
+		log.Println("Download topic error:", err)
 	}
 }
 
-func collectTopicAndAssociatedUsers(discourseClient *discourse.Client, topicID int) {
-	updatedTopic, err := discourse.GetTopicByID(discourseClient, topicID)
-	rateLimitDelay()
+// Add a downloaded topic to the cache along with any of its users not already cached
+func storeTopicAndUsers(categorySlug string, topicID int, topic *discourse.TopicData, users map[string]*discourse.TopicParticipant) {
+	cacheWriteMutex.Lock()
+	defer cacheWriteMutex.Unlock()
 
-	if err == nil {
-		additionalUsers := getUsersListedInTopic(discourseClient, updatedTopic)
+	if cache.Topics[categorySlug] == nil {
+		cache.Topics[categorySlug] = map[int]*discourse.TopicData{}
+	}
 
-		categoryData, err := discourse.ShowCategory(discourseClient, updatedTopic.CategoryID)
-		rateLimitDelay()
+	cache.Topics[categorySlug][topicID] = topic
 
-		categoryName := ""
+	for username, user := range users {
+		_, userExists := cache.Users[username]
 
-		if err != nil {
-			log.Println("Could not find category for topic ", updatedTopic.Title, "-", err)
-		} else {
-			categoryName = categoryData.Category.Slug
+		if !userExists {
+			cache.Users[username] = user
 		}
+	}
+}
 
-		cacheWriteMutex.Lock()
-		defer cacheWriteMutex.Unlock()
-		cache.Topics[categoryName] = map[int]*discourse.TopicData{topicID: updatedTopic}
+// Check if a category is one of the given categories or their subcategories
+func categoryWithinAny(categorySlug string, parentCategorySlugs []string) bool {
+	return slices.ContainsFunc(parentCategorySlugs, func(parentCategorySlug string) bool {
+		return categorySlug == parentCategorySlug || strings.HasPrefix(categorySlug, parentCategorySlug+"/")
+	})
+}
 
-		// Add newly found users
-		for username, additionalUser := range additionalUsers {
-			_, userExists := cache.Users[username]
+// Find the categories to crawl, tags are crawled instead when given
+func crawledCategories(categories []discourse.Category, itemsToExport ItemsToExport) []string {
+	categoryList := []string{}
 
-			if !userExists {
-				cache.Users[username] = additionalUser
+	if len(itemsToExport.LimitToTags) > 0 {
+		return categoryList
+	}
+
+	if len(itemsToExport.LimitToCategorySlugs) == 0 && len(itemsToExport.LimitToTopicIDs) == 0 {
+		for _, nextCategory := range categories {
+			categoryList = append(categoryList, nextCategory.Slug)
+
+			for _, nextSubcategory := range nextCategory.SubcategoryList {
+				categoryList = append(categoryList, nextCategory.Slug+"/"+nextSubcategory.Slug)
 			}
 		}
 	} else {
-		log.Println("Download topic error:", err)
+		categoryList = slices.Clone(itemsToExport.LimitToCategorySlugs)
+	}
+
+	return slices.DeleteFunc(categoryList, func(categorySlug string) bool {
+		return categoryWithinAny(categorySlug, itemsToExport.ExcludeCategorySlugs)
+	})
+}
+
+// Check if a cached topic was chosen directly or is in a crawled category or chosen tag
+func topicInScope(categorySlug string, topicID int, topic *discourse.TopicData, categoryList []string, itemsToExport ItemsToExport) bool {
+	if slices.Contains(itemsToExport.LimitToTopicIDs, topicID) {
+		return true
+	}
+
+	if categoryWithinAny(categorySlug, itemsToExport.ExcludeCategorySlugs) {
+		return false
+	}
+
+	// Tagged topics are stored under their own categories, which can be any subcategory of a chosen one
+	if len(itemsToExport.LimitToTags) > 0 {
+		return (len(itemsToExport.LimitToCategorySlugs) == 0 || categoryWithinAny(categorySlug, itemsToExport.LimitToCategorySlugs)) &&
+			slices.ContainsFunc(topic.Tags, func(tag string) bool { return slices.Contains(itemsToExport.LimitToTags, tag) })
+	}
+
+	return slices.Contains(categoryList, categorySlug)
+}
+
+// Find the parent/child slug of a category in the category list
+func categorySlugByID(categories []discourse.Category, categoryID int) (string, bool) {
+	for _, category := range categories {
+		if category.ID == categoryID {
+			return category.Slug, true
+		}
+
+		for _, subcategory := range category.SubcategoryList {
+			if subcategory.ID == categoryID {
+				return category.Slug + "/" + subcategory.Slug, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Download a topic with every post in its stream, including the raw markdown of each post if it will be exported
+func getTopicByID(ctx context.Context, discourseClient *discourse.Client, topicID int, itemsToExport ItemsToExport) (*discourse.TopicData, error) {
+	query := ""
+
+	if itemsToExport.PostRawContent {
+		query = "include_raw=true"
+	}
+
+	data, err := discourseClient.GetWithQueryString(fmt.Sprintf("t/%d", topicID), query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var topicData *discourse.TopicData
+	err = json.Unmarshal(data, &topicData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Discourse only includes the first chunk of posts, so request the rest of the stream by ID
+	loadedPosts := map[int]discourse.PostData{}
+
+	for _, post := range topicData.PostStream.Posts {
+		loadedPosts[post.ID] = post
+	}
+
+	missingPostIDs := []int{}
+
+	for _, postID := range topicData.PostStream.Stream {
+		if _, ok := loadedPosts[postID]; !ok {
+			missingPostIDs = append(missingPostIDs, postID)
+		}
+	}
+
+	if len(missingPostIDs) == 0 {
+		return topicData, nil
+	}
+
+	for batchStart := 0; batchStart < len(missingPostIDs); batchStart += topicPostBatchSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		batchEnd := min(batchStart+topicPostBatchSize, len(missingPostIDs))
+		posts, err := getTopicPostsByID(discourseClient, topicID, missingPostIDs[batchStart:batchEnd], itemsToExport)
+
+		if err != nil {
+			return nil, fmt.Errorf("topic %d posts error: %v", topicID, err)
+		}
+
+		for _, post := range posts {
+			loadedPosts[post.ID] = post
+		}
 	}
+
+	// Rebuild the post list in stream order
+	topicData.PostStream.Posts = []discourse.PostData{}
+
+	for _, postID := range topicData.PostStream.Stream {
+		if post, ok := loadedPosts[postID]; ok {
+			topicData.PostStream.Posts = append(topicData.PostStream.Posts, post)
+		}
+	}
+
+	return topicData, nil
 }
 
-func getUsersListedInTopic(discourseClient *discourse.Client, topicData *discourse.TopicData) map[string]*discourse.TopicParticipant {
+func getTopicPostsByID(discourseClient *discourse.Client, topicID int, postIDs []int, itemsToExport ItemsToExport) ([]discourse.PostData, error) {
+	query := url.Values{}
+
+	for _, postID := range postIDs {
+		query.Add("post_ids[]", strconv.Itoa(postID))
+	}
+
+	if itemsToExport.PostRawContent {
+		query.Set("include_raw", "true")
+	}
+
+	data, err := discourseClient.GetWithQueryString(fmt.Sprintf("t/%d/posts", topicID), query.Encode())
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		PostStream discourse.PostStream `json:"post_stream"`
+	}
+
+	err = json.Unmarshal(data, &response)
+	return response.PostStream.Posts, err
+}
+
+// Check if a cached topic is missing posts from its stream or the raw post content that is now requested
+func cachedTopicIncomplete(topicData *discourse.TopicData, itemsToExport ItemsToExport) bool {
+	if len(topicData.PostStream.Posts) < len(topicData.PostStream.Stream) {
+		return true
+	}
+
+	return itemsToExport.PostRawContent && len(topicData.PostStream.Posts) > 0 && topicData.PostStream.Posts[0].Raw == ""
+}
+
+func getUsersListedInTopic(ctx context.Context, discourseClient *discourse.Client, topicData *discourse.TopicData) map[string]*discourse.TopicParticipant {
 	additionalUsers := map[string]*discourse.TopicParticipant{}
 
 	for _, participant := range topicData.Details.Participants {
@@ -224,15 +660,24 @@ func getUsersListedInTopic(discourseClient *discourse.Client, topicData *discour
 
 	// Fail safe if post creators are not in participant list
 	for _, post := range topicData.PostStream.Posts {
+		if ctx.Err() != nil {
+			break
+		}
+
+		cacheWriteMutex.Lock()
 		_, userExistsInCache := cache.Users[post.Username]
+		cacheWriteMutex.Unlock()
+
 		_, userExistsInAdditional := additionalUsers[post.Username]
 
 		if !userExistsInCache && !userExistsInAdditional {
 
-			newUser, err := discourse.GetUserByUsername(discourseClient, post.Username)
-			rateLimitDelay()
+			newUser, err := withRetries(ctx, func() (*discourse.GetUserResponse, error) {
+				return discourse.GetUserByUsername(discourseClient, post.Username)
+			})
 
 			if err != nil {
+				recordFailure(ctx, "user "+post.Username)
 				log.Println("Could not find post creator by username ", post.Username, "-", err)
 				continue
 			}
@@ -249,75 +694,176 @@ func getUsersListedInTopic(discourseClient *discourse.Client, topicData *discour
 	return additionalUsers
 }
 
-func collectTopicEditsFromCacheTopicList(wg *sync.WaitGroup, discourseClient *discourse.Client, categorySlug string) {
-	defer wg.Done()
-	topics, ok := cache.Topics[categorySlug]
-
-	if !ok {
+func collectTopicEditsFromTopic(ctx context.Context, discourseClient *discourse.Client, topicID int, topic *discourse.TopicData, itemsToExport ItemsToExport) {
+	if ctx.Err() != nil || len(topic.PostStream.Posts) == 0 {
 		return
 	}
 
-	// Get all new edit pages for each topic
-	for topicID, topic := range topics {
-		collectTopicEditsFromTopic(discourseClient, topicID, topic)
+	postRevisions := map[int]map[int]*discourse.PostRevision{}
+
+	cacheWriteMutex.Lock()
+	for postID, revisions := range cache.TopicEdits[topicID] {
+		postRevisions[postID] = maps.Clone(revisions)
 	}
-}
+	cacheWriteMutex.Unlock()
 
-func collectTopicEditsFromTopic(discourseClient *discourse.Client, topicID int, topic *discourse.TopicData) {
-	revisions, ok := cache.TopicEdits[topicID]
+	posts := topic.PostStream.Posts[:1]
 
-	if !ok {
-		revisions = map[int]*discourse.PostRevision{}
+	if itemsToExport.AllPostEdits {
+		posts = topic.PostStream.Posts
 	}
 
-	topicPostID := topic.PostStream.Posts[0].ID
+	for postNum, post := range posts {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// A post last updated before the export window has no edits within it
+		if !itemsToExport.Since.IsZero() && post.UpdatedAt.Before(itemsToExport.Since) {
+			continue
+		}
+
+		var numRevisions int
+
+		// Replies rely on the version listed in the topic to avoid a revision lookup for every post
+		if postNum == 0 || post.Version == 0 {
+			var err error
+			numRevisions, err = withRetries(ctx, func() (int, error) {
+				return discourse.GetNumPostRevisionsByID(discourseClient, post.ID)
+			})
 
-	numRevisions, err := discourse.GetNumPostRevisionsByID(discourseClient, topicPostID)
-	rateLimitDelay()
+			if err != nil {
+				recordFailure(ctx, fmt.Sprintf("edit count for topic %d post %d", topicID, post.ID))
+				log.Println("Number of post edits data collection error for topic", topicID, "post", post.ID, err)
+			}
+		} else {
+			numRevisions = post.Version
+		}
+
+		revisions, ok := postRevisions[post.ID]
+
+		if !ok {
+			revisions = map[int]*discourse.PostRevision{}
+		}
+
+		if _, cached := revisions[numRevisions]; numRevisions > 1 && !cached {
+			collectPostRevisions(ctx, discourseClient, topicID, post.ID, revisions)
+		}
+
+		if len(revisions) > 0 {
+			postRevisions[post.ID] = revisions
+		}
+	}
+
+	if len(postRevisions) > 0 {
+		cacheWriteMutex.Lock()
+		defer cacheWriteMutex.Unlock()
+		cache.TopicEdits[topicID] = postRevisions
+	}
+}
+
+// Update revisions by traversing through linked list from latest to first, stopping at any already cached
+func collectPostRevisions(ctx context.Context, discourseClient *discourse.Client, topicID int, postID int, revisions map[int]*discourse.PostRevision) {
+	nextRevision, err := withRetries(ctx, func() (*discourse.PostRevision, error) {
+		return discourse.GetPostLatestRevisionByID(discourseClient, postID)
+	})
 
 	if err != nil {
-		log.Println("Number of topic edits data collection error for", topicID, err)
+		recordFailure(ctx, fmt.Sprintf("latest edit for topic %d post %d", topicID, postID))
+		log.Println("Post edits data collection error for topic", topicID, "post", postID, "revision latest", err)
+		return
 	}
 
-	if numRevisions > 1 {
+	currentRevisionNum := nextRevision.CurrentRevision
+	addedRevisionNums := []int{}
+
+	for {
+		revisions[currentRevisionNum] = nextRevision
+		addedRevisionNums = append(addedRevisionNums, currentRevisionNum)
+
+		if currentRevisionNum == nextRevision.FirstRevision {
+			break
+		}
+
+		currentRevisionNum = nextRevision.PreviousRevision
+
+		if _, cached := revisions[currentRevisionNum]; cached {
+			break
+		}
+
+		// Drop a partial chain when stopping so the next run starts again from the latest revision
+		if ctx.Err() != nil {
+			for _, revisionNum := range addedRevisionNums {
+				delete(revisions, revisionNum)
+			}
+
+			return
+		}
 
-		// Update revisions by traversing through linked list from latest to first
-		nextRevision, err := discourse.GetPostLatestRevisionByID(discourseClient, topicPostID)
-		rateLimitDelay()
+		nextRevision, err = withRetries(ctx, func() (*discourse.PostRevision, error) {
+			return discourse.GetPostRevisionByID(discourseClient, postID, currentRevisionNum)
+		})
 
 		if err != nil {
-			log.Println("Topic edits data collection error for", topicID, "revision latest", err)
-		} else {
-			currentRevisionNum := nextRevision.CurrentRevision
-			for {
-				revisions[currentRevisionNum] = nextRevision
+			recordFailure(ctx, fmt.Sprintf("edit %d for topic %d post %d", currentRevisionNum, topicID, postID))
+			log.Println("Post edits data collection error for topic", topicID, "post", postID, "revision", currentRevisionNum, err)
+			break
+		}
+	}
+}
 
-				if currentRevisionNum == nextRevision.FirstRevision {
-					break
-				}
+// Run a job for each item, with up to concurrency jobs running at once
+func runWorkerPool[T any](concurrency int, items []T, job func(item T)) {
+	var workerWg sync.WaitGroup
+	jobs := make(chan T)
 
-				currentRevisionNum = nextRevision.PreviousRevision
+	for range min(concurrency, len(items)) {
+		workerWg.Add(1)
 
-				nextRevision, err = discourse.GetPostRevisionByID(discourseClient, topicPostID, currentRevisionNum)
-				rateLimitDelay()
+		go func() {
+			defer workerWg.Done()
 
-				if err != nil {
-					log.Println("Topic edits data collection error for", topicID, "revision", currentRevisionNum, err)
-					break
-				}
+			for item := range jobs {
+				job(item)
 			}
-		}
+		}()
 	}
 
-	if len(revisions) > 0 {
-		cacheWriteMutex.Lock()
-		defer cacheWriteMutex.Unlock()
-		cache.TopicEdits[topicID] = revisions
+	for _, item := range items {
+		jobs <- item
 	}
+
+	close(jobs)
+	workerWg.Wait()
 }
 
-func rateLimitDelay() {
-	rateLimitMutex.Lock()
-	defer rateLimitMutex.Unlock()
-	time.Sleep(rateLimitDuration)
+// Call the Discourse site, retrying with exponential backoff when a call fails before getting an HTTP error status.
+// The HTTP transport already retries error statuses that are worth retrying.
+func withRetries[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	result, err := call()
+
+	for attempt := 0; err != nil && attempt < callRetries && !strings.HasPrefix(err.Error(), "HTTP Status Error"); attempt++ {
+		retryDelay := callRetryBackoff << attempt
+		log.Printf("Discourse call failed, retrying in %v - %v", retryDelay, err)
+
+		if sleepContext(ctx, retryDelay) != nil {
+			break
+		}
+
+		result, err = call()
+	}
+
+	return result, err
+}
+
+// List an item that could not be collected in the collection summary
+func recordFailure(ctx context.Context, item string) {
+	// Calls cut short by a stop request are not failures
+	if ctx.Err() != nil {
+		return
+	}
+
+	failedItemsMutex.Lock()
+	defer failedItemsMutex.Unlock()
+	failedItems = append(failedItems, item)
 }
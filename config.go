@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Section of a config file holding named sets of options that override the top level ones
+const configProfilesKey = "profiles"
+
+// Load option values from a YAML config file as flag defaults, so anything given on the command line or through an
+// environment variable still takes precedence. Options from the chosen profile replace those at the top level.
+// Returns the names of the options that were set.
+func LoadConfig(app *kingpin.Application, path string, profile string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("config read error: %v", err)
+	}
+
+	var config map[string]any
+	err = yaml.Unmarshal(data, &config)
+
+	if err != nil {
+		return nil, fmt.Errorf("config parse error: %v", err)
+	}
+
+	profiles, ok := config[configProfilesKey].(map[string]any)
+
+	if !ok && config[configProfilesKey] != nil {
+		return nil, fmt.Errorf("config error: %s must map profile names to options", configProfilesKey)
+	}
+
+	delete(config, configProfilesKey)
+
+	values := map[string][]string{}
+	err = flattenConfig("", config, values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if profile != "" {
+		profileConfig, ok := profiles[profile].(map[string]any)
+
+		if !ok {
+			return nil, fmt.Errorf("config error: no profile named %s in %s", profile, path)
+		}
+
+		err = flattenConfig("", profileConfig, values)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configuredFlags := map[string]bool{}
+
+	for name, flagValues := range values {
+		flag := app.GetFlag(name)
+
+		if flag == nil || name == "config" || name == "profile" || name == "help" {
+			return nil, fmt.Errorf("config error: unknown option %s", name)
+		}
+
+		flag.Default(flagValues...)
+		configuredFlags[name] = true
+	}
+
+	return configuredFlags, nil
+}
+
+// Convert nested config sections into flag names and values, so discourse: {site-url: x} sets --discourse.site-url
+func flattenConfig(prefix string, config map[string]any, values map[string][]string) error {
+	for key, value := range config {
+		name := key
+
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+
+		switch typedValue := value.(type) {
+		case map[string]any:
+			err := flattenConfig(name, typedValue, values)
+
+			if err != nil {
+				return err
+			}
+		case []any:
+			flagValues := []string{}
+
+			for _, item := range typedValue {
+				switch item.(type) {
+				case map[string]any, []any, nil:
+					return fmt.Errorf("config error: %s must be a list of single values", name)
+				}
+
+				flagValues = append(flagValues, configValueString(item))
+			}
+
+			values[name] = flagValues
+		case nil:
+			return fmt.Errorf("config error: %s has no value", name)
+		default:
+			values[name] = []string{configValueString(typedValue)}
+		}
+	}
+
+	return nil
+}
+
+// Unquoted dates and times are decoded by YAML, so write them back in a form the options accept
+func configValueString(value any) string {
+	if timeValue, ok := value.(time.Time); ok {
+		if timeValue.Equal(timeValue.Truncate(24 * time.Hour)) {
+			return timeValue.Format(time.DateOnly)
+		}
+
+		return timeValue.Format(time.RFC3339)
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Find the value of an option on the command line before it is parsed
+func argValue(args []string, flagName string) string {
+	value := ""
+
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		if arg == "--"+flagName && i+1 < len(args) {
+			value = args[i+1]
+		} else if flagValue, found := strings.CutPrefix(arg, "--"+flagName+"="); found {
+			value = flagValue
+		}
+	}
+
+	return value
+}
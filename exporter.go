@@ -1,65 +1,238 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/lvoytek/discourse_client_go/pkg/discourse"
 )
 
-func InitExporter(exportType string, mysqlServerURL string, mysqlUsername string, mysqlPassword string, csvFoldername string) error {
-	if exportType == "mysql" {
-		err := ConnectMySQL(mysqlServerURL, mysqlUsername, mysqlPassword)
+// Destination for collected Discourse data, exports stop between rows once the context is cancelled
+type Exporter interface {
+	Init() error
+	ExportUsers(ctx context.Context, users []UserEntry) error
+	ExportCategories(ctx context.Context, categories []CategoryEntry) error
+	ExportTopics(ctx context.Context, topics []TopicEntry) error
+	ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error
+	ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error
+	Close() error
+}
+
+// Implemented by exporters whose posts and edits reference the exported users, so users are always exported alongside them
+type UserReferencingExporter interface {
+	RequiresUsers() bool
+}
+
+// Implemented by exporters that write every dataset of an export run at once
+type FlushingExporter interface {
+	Flush() error
+}
+
+// Settings available to exporters when they are created
+type ExporterConfig struct {
+	MySQLServerURL string
+	MySQLUsername  string
+	MySQLPassword  string
+	MySQLDatabase  string
+	MySQLSocket    string
+	MySQLTLSMode   string
+	MySQLTLSCAPath string
+	MySQLDSN       string
+
+	MySQLMaxOpenConns    int
+	MySQLMaxIdleConns    int
+	MySQLConnMaxLifetime time.Duration
+	MySQLBatchSize       int
+
+	PostgresServerURL string
+	PostgresUsername  string
+	PostgresPassword  string
+	PostgresSSLMode   string
+
+	SQLitePath string
+
+	CSVFoldername string
+
+	// Whether post content is exported, for exporters with a fixed set of columns
+	PostRawContent    bool
+	PostCookedContent bool
+}
+
+type ExporterFactory func(config ExporterConfig) Exporter
+
+var (
+	exporterRegistry = map[string]ExporterFactory{}
+)
+
+// Make an exporter available as a --data.export-type option
+func RegisterExporter(name string, factory ExporterFactory) {
+	exporterRegistry[name] = factory
+}
+
+func ExporterNames() (names []string) {
+	for name := range exporterRegistry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Exporter created for the current run, along with the export type that selected it
+type ConfiguredExporter struct {
+	Name string
+	Exporter
+}
+
+func InitExporter(exportType string, config ExporterConfig) (Exporter, error) {
+	factory, ok := exporterRegistry[exportType]
+
+	if !ok {
+		return nil, fmt.Errorf("invalid exporter type: %s", exportType)
+	}
+
+	exporter := factory(config)
+	return exporter, exporter.Init()
+}
+
+// Set up an exporter for each requested export type, closing them all if any fail
+func InitExporters(exportTypes []string, config ExporterConfig) ([]ConfiguredExporter, error) {
+	exporters := []ConfiguredExporter{}
+
+	for _, exportType := range exportTypes {
+		if slices.ContainsFunc(exporters, func(exporter ConfiguredExporter) bool { return exporter.Name == exportType }) {
+			continue
+		}
+
+		exporter, err := InitExporter(exportType, config)
 
 		if err != nil {
-			return err
+			if exporter != nil {
+				exporter.Close()
+			}
+
+			CloseExporters(exporters)
+			return nil, fmt.Errorf("%s exporter: %v", exportType, err)
 		}
 
-		return InitializeMySQLDatabase()
-	} else if exportType == "csv" {
-		return SetCSVFolder(csvFoldername)
-	} else if exportType == "json" {
-		return nil
+		exporters = append(exporters, ConfiguredExporter{Name: exportType, Exporter: exporter})
 	}
 
-	return fmt.Errorf("invalid exporter type: %s", exportType)
+	return exporters, nil
+}
+
+func CloseExporters(exporters []ConfiguredExporter) {
+	for _, exporter := range exporters {
+		err := exporter.Close()
+
+		if err != nil {
+			log.Println("Unable to close", exporter.Name, "exporter -", err)
+		}
+	}
 }
 
-func ExportAll(cache DiscourseCache, exportType string, itemsToExport ItemsToExport) {
+// Send the collected data to every exporter, returning an error naming those that did not fully succeed
+func ExportAll(ctx context.Context, cache DiscourseCache, exporters []ConfiguredExporter, itemsToExport ItemsToExport) error {
+	// The cache can hold topics from earlier runs with other filters, so only export those the current run covers
+	categoryList := crawledCategories(cache.Categories, itemsToExport)
+
 	dataToExport := DataToExport{
-		Users: userMapToUserEntry(cache.Users),
-		Posts: topicMapToTopicComments(cache.Topics),
-		Edits: topicRevisionMapToTopicEdits(cache.TopicEdits),
+		Users:      userMapToUserEntry(cache.Users),
+		Categories: categoryListToCategoryEntry(cache.Categories),
+		Topics:     topicMapToTopicEntry(cache.Topics, categoryList, itemsToExport),
+		Posts:      topicMapToTopicComments(cache.Topics, categoryList, itemsToExport),
+		Edits:      topicRevisionMapToTopicEdits(cache.TopicEdits, cache.Topics, categoryList, itemsToExport),
 	}
 
-	if exportType == "mysql" {
-		if itemsToExport.TopicComments || itemsToExport.TopicEdits || itemsToExport.Users {
-			ExportUsersMySQL(dataToExport.Users)
+	failedExporters := []string{}
+
+	for _, exporter := range exporters {
+		if !exportData(ctx, exporter, dataToExport, itemsToExport) {
+			failedExporters = append(failedExporters, exporter.Name)
 		}
+	}
+
+	if len(failedExporters) > 0 {
+		return fmt.Errorf("export incomplete for: %s", strings.Join(failedExporters, ", "))
+	}
+
+	return nil
+}
+
+func exportData(ctx context.Context, exporter ConfiguredExporter, dataToExport DataToExport, itemsToExport ItemsToExport) bool {
+	succeeded := true
 
-		if itemsToExport.TopicComments {
-			ExportTopicCommentsMySQL(dataToExport.Posts)
+	if itemsToExport.Users || (exporterRequiresUsers(exporter.Exporter) && (itemsToExport.TopicComments || itemsToExport.TopicEdits)) {
+		err := exporter.ExportUsers(ctx, dataToExport.Users)
+
+		if err != nil {
+			log.Println(exporter.Name, "user export error:", err)
+			succeeded = false
 		}
+	}
+
+	if itemsToExport.Categories {
+		err := exporter.ExportCategories(ctx, dataToExport.Categories)
 
-		if itemsToExport.TopicEdits {
-			ExportTopicEditsMySQL(dataToExport.Edits)
+		if err != nil {
+			log.Println(exporter.Name, "category export error:", err)
+			succeeded = false
 		}
+	}
 
-	} else if exportType == "csv" {
-		if itemsToExport.Users {
-			ExportUsersCSV(dataToExport.Users)
+	if itemsToExport.Topics {
+		err := exporter.ExportTopics(ctx, dataToExport.Topics)
+
+		if err != nil {
+			log.Println(exporter.Name, "topic export error:", err)
+			succeeded = false
 		}
+	}
+
+	if itemsToExport.TopicComments {
+		err := exporter.ExportPosts(ctx, dataToExport.Posts)
 
-		if itemsToExport.TopicComments {
-			ExportTopicCommentsCSV(dataToExport.Posts)
+		if err != nil {
+			log.Println(exporter.Name, "post export error:", err)
+			succeeded = false
 		}
+	}
 
-		if itemsToExport.TopicEdits {
-			ExportTopicEditsCSV(dataToExport.Edits)
+	if itemsToExport.TopicEdits {
+		err := exporter.ExportEdits(ctx, dataToExport.Edits)
+
+		if err != nil {
+			log.Println(exporter.Name, "edit export error:", err)
+			succeeded = false
 		}
+	}
 
-	} else if exportType == "json" {
-		ExportJSON(dataToExport, itemsToExport)
+	// Leave out the final write of a partial export when stopping
+	if ctx.Err() != nil {
+		return false
 	}
+
+	if flushingExporter, ok := exporter.Exporter.(FlushingExporter); ok {
+		err := flushingExporter.Flush()
+
+		if err != nil {
+			log.Println(exporter.Name, "export flush error:", err)
+			succeeded = false
+		}
+	}
+
+	return succeeded
+}
+
+func exporterRequiresUsers(exporter Exporter) bool {
+	userReferencingExporter, ok := exporter.(UserReferencingExporter)
+	return ok && userReferencingExporter.RequiresUsers()
 }
 
 func userMapToUserEntry(users map[string]*discourse.TopicParticipant) (userEntries []UserEntry) {
@@ -75,11 +248,76 @@ func userMapToUserEntry(users map[string]*discourse.TopicParticipant) (userEntri
 	return userEntries
 }
 
-func topicMapToTopicComments(topics map[string]map[int]*discourse.TopicData) (topicComments []TopicCommentsEntry) {
+// Flatten categories and their subcategories, using the same parent/child slugs as exported posts
+func categoryListToCategoryEntry(categories []discourse.Category) (categoryEntries []CategoryEntry) {
+	for _, category := range categories {
+		categoryEntries = append(categoryEntries, categoryToCategoryEntry(category.CategoryWithoutSubcategories, category.Slug, 0))
+
+		for _, subcategory := range category.SubcategoryList {
+			categoryEntries = append(categoryEntries, categoryToCategoryEntry(subcategory, category.Slug+"/"+subcategory.Slug, category.ID))
+		}
+	}
+
+	return categoryEntries
+}
+
+func categoryToCategoryEntry(category discourse.CategoryWithoutSubcategories, slug string, parentCategoryID int) CategoryEntry {
+	return CategoryEntry{
+		CategoryID:       category.ID,
+		Slug:             slug,
+		Name:             category.Name,
+		ParentCategoryID: parentCategoryID,
+		Description:      category.DescriptionText,
+		TopicCount:       category.TopicCount,
+		PostCount:        category.PostCount,
+		Color:            category.Color,
+		IsReadRestricted: category.ReadRestricted,
+	}
+}
+
+func topicMapToTopicEntry(topics map[string]map[int]*discourse.TopicData, categoryList []string, itemsToExport ItemsToExport) (topicEntries []TopicEntry) {
 	for category_slug, topic_list := range topics {
 		for topic_id, topic := range topic_list {
+			if !topicInScope(category_slug, topic_id, topic, categoryList, itemsToExport) || !itemsToExport.TopicInTimeWindow(topic.CreatedAt, topic.LastPostedAt) {
+				continue
+			}
+
+			topicEntries = append(topicEntries, TopicEntry{
+				TopicID:        topic_id,
+				Title:          topic.Title,
+				Slug:           topic.Slug,
+				CategorySlug:   category_slug,
+				CategoryID:     topic.CategoryID,
+				Tags:           topic.Tags,
+				CreationTime:   topic.CreatedAt,
+				LastPostedTime: topic.LastPostedAt,
+				Views:          topic.Views,
+				LikeCount:      topic.LikeCount,
+				ReplyCount:     topic.ReplyCount,
+				PostersCount:   topic.ParticipantCount,
+				IsClosed:       topic.Closed,
+				IsArchived:     topic.Archived,
+				IsPinned:       topic.Pinned || topic.PinnedGlobally,
+			})
+		}
+	}
+
+	return topicEntries
+}
+
+func topicMapToTopicComments(topics map[string]map[int]*discourse.TopicData, categoryList []string, itemsToExport ItemsToExport) (topicComments []TopicCommentsEntry) {
+	for category_slug, topic_list := range topics {
+		for topic_id, topic := range topic_list {
+			if !topicInScope(category_slug, topic_id, topic, categoryList, itemsToExport) {
+				continue
+			}
+
 			for postNum, post := range topic.PostStream.Posts {
-				topicComments = append(topicComments, TopicCommentsEntry{
+				if !itemsToExport.InTimeWindow(post.CreatedAt) {
+					continue
+				}
+
+				topicComment := TopicCommentsEntry{
 					CategorySlug:  category_slug,
 					TopicID:       topic_id,
 					PostID:        post.ID,
@@ -87,7 +325,17 @@ func topicMapToTopicComments(topics map[string]map[int]*discourse.TopicData) (to
 					UpdateTime:    post.UpdatedAt,
 					Username:      post.Username,
 					IsInitialPost: postNum == 0,
-				})
+				}
+
+				if itemsToExport.PostRawContent {
+					topicComment.RawContent = post.Raw
+				}
+
+				if itemsToExport.PostCookedContent {
+					topicComment.CookedContent = post.Cooked
+				}
+
+				topicComments = append(topicComments, topicComment)
 			}
 		}
 	}
@@ -95,17 +343,79 @@ func topicMapToTopicComments(topics map[string]map[int]*discourse.TopicData) (to
 	return topicComments
 }
 
-func topicRevisionMapToTopicEdits(revisions map[int]map[int]*discourse.PostRevision) (topicEdits []TopicEditsEntry) {
+func topicRevisionMapToTopicEdits(revisions map[int]map[int]map[int]*discourse.PostRevision, topics map[string]map[int]*discourse.TopicData, categoryList []string, itemsToExport ItemsToExport) (topicEdits []TopicEditsEntry) {
+	// Find the topics in scope, and each topic's main post to leave out replies when they are not in scope
+	scopedTopicIDs := map[int]bool{}
+	initialPostIDs := map[int]bool{}
+
+	for category_slug, topic_list := range topics {
+		for topic_id, topic := range topic_list {
+			if !topicInScope(category_slug, topic_id, topic, categoryList, itemsToExport) {
+				continue
+			}
+
+			scopedTopicIDs[topic_id] = true
+
+			if len(topic.PostStream.Posts) > 0 {
+				initialPostIDs[topic.PostStream.Posts[0].ID] = true
+			}
+		}
+	}
+
 	for topic_id, topicRevisions := range revisions {
-		for revision_index, topicRevision := range topicRevisions {
-			topicEdits = append(topicEdits, TopicEditsEntry{
-				TopicID:      topic_id,
-				EditNumber:   revision_index,
-				CreationTime: topicRevision.CreatedAt,
-				Username:     topicRevision.Username,
-			})
+		if !scopedTopicIDs[topic_id] {
+			continue
+		}
+
+		for post_id, postRevisions := range topicRevisions {
+			if !itemsToExport.AllPostEdits && !initialPostIDs[post_id] {
+				continue
+			}
+
+			for revision_index, postRevision := range postRevisions {
+				if !itemsToExport.InTimeWindow(postRevision.CreatedAt) {
+					continue
+				}
+
+				topicEdits = append(topicEdits, TopicEditsEntry{
+					TopicID:      topic_id,
+					PostID:       post_id,
+					EditNumber:   revision_index,
+					CreationTime: postRevision.CreatedAt,
+					Username:     postRevision.Username,
+				})
+			}
 		}
 	}
 
 	return topicEdits
 }
+
+// Store empty strings as NULL so upserts keep previously exported values
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// Store unset IDs as NULL
+func nullableInt(value int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(value), Valid: value != 0}
+}
+
+// Store unset times as NULL instead of the zero date
+func nullableTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
+
+// Summarize rows that could not be written by a row-at-a-time exporter
+func exportFailureError(failed int, total int, dataset string) error {
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d %s could not be exported", failed, total, dataset)
+}
+
+// Report where a row-at-a-time export stopped after its context was cancelled
+func exportStoppedError(exported int, total int, dataset string) error {
+	return fmt.Errorf("export stopped after %d of %d %s", exported, total, dataset)
+}
@@ -1,73 +1,136 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"time"
 )
 
-var (
-	CSVFoldername string
-)
+type CSVExporter struct {
+	foldername string
 
-func SetCSVFolder(foldername string) error {
-	CSVFoldername = foldername
-	return os.Mkdir(foldername, 0755)
+	// Post content columns, left out unless their content is exported
+	postRawContent    bool
+	postCookedContent bool
 }
 
-func ExportUsersCSV(users []UserEntry) {
-	err := exportArrayToCSV("users.csv", users)
+func init() {
+	RegisterExporter("csv", NewCSVExporter)
+}
 
-	if err != nil {
-		log.Printf("ExportUsersCSV error: %v", err)
+func NewCSVExporter(config ExporterConfig) Exporter {
+	return &CSVExporter{
+		foldername: config.CSVFoldername,
+
+		postRawContent:    config.PostRawContent,
+		postCookedContent: config.PostCookedContent,
 	}
 }
 
-func ExportTopicCommentsCSV(topicComments []TopicCommentsEntry) {
-	err := exportArrayToCSV("topic_comments.csv", topicComments)
+func (exporter *CSVExporter) Init() error {
+	return os.MkdirAll(exporter.foldername, 0755)
+}
 
-	if err != nil {
-		log.Printf("ExportTopicCommentsCSV error: %v", err)
-	}
+func (exporter *CSVExporter) Close() error {
+	return nil
 }
 
-func ExportTopicEditsCSV(topicEdits []TopicEditsEntry) {
-	err := exportArrayToCSV("topic_edits.csv", topicEdits)
+func (exporter *CSVExporter) ExportUsers(ctx context.Context, users []UserEntry) error {
+	return exportArrayToCSV(ctx, filepath.Join(exporter.foldername, "users.csv"), users, nil)
+}
 
-	if err != nil {
-		log.Printf("ExportTopicEditsCSV error: %v", err)
+func (exporter *CSVExporter) ExportCategories(ctx context.Context, categories []CategoryEntry) error {
+	return exportArrayToCSV(ctx, filepath.Join(exporter.foldername, "categories.csv"), categories, nil)
+}
+
+func (exporter *CSVExporter) ExportTopics(ctx context.Context, topics []TopicEntry) error {
+	return exportArrayToCSV(ctx, filepath.Join(exporter.foldername, "topics.csv"), topics, nil)
+}
+
+func (exporter *CSVExporter) ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error {
+	omitColumns := []string{}
+
+	if !exporter.postRawContent {
+		omitColumns = append(omitColumns, "Raw Content")
+	}
+
+	if !exporter.postCookedContent {
+		omitColumns = append(omitColumns, "Cooked Content")
 	}
+
+	return exportArrayToCSV(ctx, filepath.Join(exporter.foldername, "topic_comments.csv"), topicComments, omitColumns)
 }
 
-func exportArrayToCSV[T any](filename string, dataSet []T) error {
+func (exporter *CSVExporter) ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error {
+	return exportArrayToCSV(ctx, filepath.Join(exporter.foldername, "topic_edits.csv"), topicEdits, nil)
+}
+
+// Write a dataset to a temporary file and move it into place, so a stopped export never leaves a partial file.
+// Columns with their header in omitColumns are left out.
+func exportArrayToCSV[T any](ctx context.Context, path string, dataSet []T, omitColumns []string) error {
 	if len(dataSet) == 0 {
 		return nil
 	}
 
-	csvFile, err := os.Create(filepath.Join(CSVFoldername, filename))
+	// Finish a file that is already being written, but do not start a new one
+	if ctx.Err() != nil {
+		return fmt.Errorf("export stopped before writing %s", filepath.Base(path))
+	}
+
+	csvFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(csvFile.Name())
+
+	// Temporary files are only readable by the owner, so match the permissions of a newly created file
+	err = csvFile.Chmod(0644)
+
+	if err == nil {
+		err = writeArrayToCSV(csvFile, dataSet, omitColumns)
+	}
+
+	closeErr := csvFile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
 
 	if err != nil {
 		return err
 	}
 
-	defer csvFile.Close()
+	return os.Rename(csvFile.Name(), path)
+}
 
+func writeArrayToCSV[T any](csvFile *os.File, dataSet []T, omitColumns []string) error {
 	writer := csv.NewWriter(csvFile)
-	defer writer.Flush()
 
 	// Get csv headers and write to file
 	dataFields := reflect.TypeOf(dataSet[0])
 	csvHeaders := []string{}
+	columnFields := []int{}
 
 	for i := 0; i < dataFields.NumField(); i++ {
-		csvHeaders = append(csvHeaders, dataFields.Field(i).Tag.Get("csv"))
+		header := dataFields.Field(i).Tag.Get("csv")
+
+		if slices.Contains(omitColumns, header) {
+			continue
+		}
+
+		csvHeaders = append(csvHeaders, header)
+		columnFields = append(columnFields, i)
 	}
 
-	err = writer.Write(csvHeaders)
+	err := writer.Write(csvHeaders)
 
 	if err != nil {
 		return err
@@ -77,7 +140,7 @@ func exportArrayToCSV[T any](filename string, dataSet []T) error {
 	for _, nextEntry := range dataSet {
 		nextEntryStrings := []string{}
 		fields := reflect.ValueOf(nextEntry)
-		for i := 0; i < fields.NumField(); i++ {
+		for _, i := range columnFields {
 			field := fields.Field(i)
 
 			// Convert each field to string based on its kind
@@ -88,6 +151,13 @@ func exportArrayToCSV[T any](filename string, dataSet []T) error {
 				nextEntryStrings = append(nextEntryStrings, fmt.Sprintf("%d", field.Int()))
 			case reflect.Bool:
 				nextEntryStrings = append(nextEntryStrings, fmt.Sprintf("%t", field.Bool()))
+			case reflect.Slice:
+				if field.Type().Elem().Kind() == reflect.String {
+					// Join string lists such as tags into a single cell
+					nextEntryStrings = append(nextEntryStrings, strings.Join(field.Interface().([]string), ","))
+				} else {
+					nextEntryStrings = append(nextEntryStrings, "")
+				}
 			case reflect.Struct:
 				if field.Type() == reflect.TypeOf(time.Time{}) {
 					// Format time.Time as string
@@ -104,5 +174,6 @@ func exportArrayToCSV[T any](filename string, dataSet []T) error {
 		}
 	}
 
-	return nil
+	writer.Flush()
+	return writer.Error()
 }
@@ -1,29 +1,65 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 )
 
-func ExportJSON(data DataToExport, itemsToExport ItemsToExport) {
-	if !itemsToExport.Users {
-		data.Users = nil
-	}
+type JSONExporter struct {
+	data DataToExport
+}
 
-	if !itemsToExport.TopicComments {
-		data.Posts = nil
-	}
+func init() {
+	RegisterExporter("json", NewJSONExporter)
+}
 
-	if !itemsToExport.TopicEdits {
-		data.Edits = nil
-	}
+func NewJSONExporter(config ExporterConfig) Exporter {
+	return &JSONExporter{}
+}
+
+func (exporter *JSONExporter) Init() error {
+	return nil
+}
+
+func (exporter *JSONExporter) Close() error {
+	return nil
+}
+
+func (exporter *JSONExporter) ExportUsers(ctx context.Context, users []UserEntry) error {
+	exporter.data.Users = users
+	return nil
+}
+
+func (exporter *JSONExporter) ExportCategories(ctx context.Context, categories []CategoryEntry) error {
+	exporter.data.Categories = categories
+	return nil
+}
+
+func (exporter *JSONExporter) ExportTopics(ctx context.Context, topics []TopicEntry) error {
+	exporter.data.Topics = topics
+	return nil
+}
+
+func (exporter *JSONExporter) ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error {
+	exporter.data.Posts = topicComments
+	return nil
+}
+
+func (exporter *JSONExporter) ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error {
+	exporter.data.Edits = topicEdits
+	return nil
+}
 
-	jsonData, err := json.Marshal(data)
+// Print every dataset given since the last flush as a single JSON object
+func (exporter *JSONExporter) Flush() error {
+	jsonData, err := json.Marshal(exporter.data)
+	exporter.data = DataToExport{}
 
 	if err != nil {
-		log.Printf("ExportJSON error: %v", err)
+		return err
 	}
 
 	fmt.Println(string(jsonData))
+	return nil
 }
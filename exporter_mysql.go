@@ -1,39 +1,123 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 )
 
-var (
-	mysqlDB *sql.DB
-)
+// Most placeholders MySQL allows in one prepared statement
+const mysqlMaxPlaceholders = 65535
 
-func ConnectMySQL(serverURL string, username string, password string) error {
-	mysqlCfg := mysql.Config{
-		User:   username,
-		Passwd: password,
-		Net:    "tcp",
-		Addr:   serverURL,
-		DBName: "discourse",
+// Name the --mysql.tls-ca certificate is registered with the MySQL driver under
+const mysqlCustomTLSConfig = "dscexporter"
+
+// Table written to by a multi-row insert, updating rows that already exist with the update clause,
+// or skipping them if there is none. Rows with a value in any of the key columns already in the table exist.
+type mysqlBatchInsert struct {
+	dataset    string
+	table      string
+	columns    []string
+	keyColumns []string
+	update     string
+}
+
+type MySQLExporter struct {
+	serverURL string
+	username  string
+	password  string
+	database  string
+	socket    string
+	tlsMode   string
+	tlsCAPath string
+	dsn       string
+
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	batchSize       int
+
+	db *sql.DB
+	// Transaction holding every dataset of the current export run, committed by Flush
+	tx *sql.Tx
+	// Set when a dataset of the current run failed, so the rest of the run is rolled back as well
+	txFailed bool
+}
+
+func init() {
+	RegisterExporter("mysql", NewMySQLExporter)
+}
+
+func NewMySQLExporter(config ExporterConfig) Exporter {
+	return &MySQLExporter{
+		serverURL: config.MySQLServerURL,
+		username:  config.MySQLUsername,
+		password:  config.MySQLPassword,
+		database:  config.MySQLDatabase,
+		socket:    config.MySQLSocket,
+		tlsMode:   config.MySQLTLSMode,
+		tlsCAPath: config.MySQLTLSCAPath,
+		dsn:       config.MySQLDSN,
+
+		maxOpenConns:    config.MySQLMaxOpenConns,
+		maxIdleConns:    config.MySQLMaxIdleConns,
+		connMaxLifetime: config.MySQLConnMaxLifetime,
+		batchSize:       config.MySQLBatchSize,
 	}
+}
 
-	var err error
-	mysqlDB, err = sql.Open("mysql", mysqlCfg.FormatDSN())
+func (exporter *MySQLExporter) Init() error {
+	err := exporter.connect()
+
+	if err != nil {
+		return err
+	}
+
+	return exporter.initializeDatabase()
+}
+
+func (exporter *MySQLExporter) RequiresUsers() bool {
+	return true
+}
+
+func (exporter *MySQLExporter) Close() error {
+	if exporter.db == nil {
+		return nil
+	}
+
+	// Roll back a run that was stopped before it could be committed
+	exporter.rollback()
+
+	return exporter.db.Close()
+}
+
+func (exporter *MySQLExporter) connect() error {
+	dsn, err := exporter.dataSourceName()
+
+	if err != nil {
+		return fmt.Errorf("mysql connection setup error: %v", err)
+	}
+
+	exporter.db, err = sql.Open("mysql", dsn)
 
 	if err != nil {
 		return fmt.Errorf("mysql connection setup error: %v", err)
 	}
 
-	mysqlDB.SetConnMaxLifetime(time.Minute * 3)
-	mysqlDB.SetMaxOpenConns(10)
-	mysqlDB.SetMaxIdleConns(10)
+	exporter.db.SetConnMaxLifetime(exporter.connMaxLifetime)
+	exporter.db.SetMaxOpenConns(exporter.maxOpenConns)
+	exporter.db.SetMaxIdleConns(exporter.maxIdleConns)
 
-	err = mysqlDB.Ping()
+	err = exporter.db.Ping()
 
 	if err != nil {
 		return fmt.Errorf("mysql database ping error: %v", err)
@@ -42,9 +126,58 @@ func ConnectMySQL(serverURL string, username string, password string) error {
 	return nil
 }
 
-func InitializeMySQLDatabase() error {
+// Build the connection string from the connection options, unless a full one was given
+func (exporter *MySQLExporter) dataSourceName() (string, error) {
+	if exporter.dsn != "" {
+		return exporter.dsn, nil
+	}
+
+	mysqlCfg := mysql.Config{
+		User:      exporter.username,
+		Passwd:    exporter.password,
+		Net:       "tcp",
+		Addr:      exporter.serverURL,
+		DBName:    exporter.database,
+		TLSConfig: exporter.tlsMode,
+	}
+
+	if exporter.socket != "" {
+		mysqlCfg.Net = "unix"
+		mysqlCfg.Addr = exporter.socket
+	}
+
+	if exporter.tlsCAPath != "" {
+		if exporter.tlsMode != "true" {
+			return "", fmt.Errorf("mysql.tls-ca requires mysql.tls to be true")
+		}
+
+		caCert, err := os.ReadFile(exporter.tlsCAPath)
+
+		if err != nil {
+			return "", fmt.Errorf("tls ca read error: %v", err)
+		}
+
+		rootCAs := x509.NewCertPool()
+
+		if !rootCAs.AppendCertsFromPEM(caCert) {
+			return "", fmt.Errorf("no PEM certificates found in %s", exporter.tlsCAPath)
+		}
+
+		err = mysql.RegisterTLSConfig(mysqlCustomTLSConfig, &tls.Config{RootCAs: rootCAs})
+
+		if err != nil {
+			return "", fmt.Errorf("tls config error: %v", err)
+		}
+
+		mysqlCfg.TLSConfig = mysqlCustomTLSConfig
+	}
+
+	return mysqlCfg.FormatDSN(), nil
+}
+
+func (exporter *MySQLExporter) initializeDatabase() error {
 	// Users
-	_, err := mysqlDB.Exec("CREATE TABLE IF NOT EXISTS users " +
+	_, err := exporter.db.Exec("CREATE TABLE IF NOT EXISTS users " +
 		"(" +
 		"user_id INT PRIMARY KEY, " +
 		"username VARCHAR(120) UNIQUE NOT NULL, " +
@@ -57,8 +190,50 @@ func InitializeMySQLDatabase() error {
 		return fmt.Errorf("users table creation error: %v", err)
 	}
 
+	// Categories
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS categories " +
+		"(" +
+		"category_id INT PRIMARY KEY, " +
+		"slug VARCHAR(255) NOT NULL, " +
+		"name VARCHAR(120) NOT NULL, " +
+		"parent_category_id INT, " +
+		"description TEXT, " +
+		"topic_count INT NOT NULL, " +
+		"post_count INT NOT NULL, " +
+		"color VARCHAR(10), " +
+		"is_read_restricted BOOL NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("categories table creation error: %v", err)
+	}
+
+	// Topics
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS topics " +
+		"(" +
+		"topic_id INT PRIMARY KEY, " +
+		"title TEXT NOT NULL, " +
+		"slug VARCHAR(255) NOT NULL, " +
+		"category_slug TEXT NOT NULL, " +
+		"category_id INT NOT NULL, " +
+		"tags TEXT, " +
+		"creation_time DATETIME NOT NULL, " +
+		"last_posted_time DATETIME, " +
+		"views INT NOT NULL, " +
+		"like_count INT NOT NULL, " +
+		"reply_count INT NOT NULL, " +
+		"posters_count INT NOT NULL, " +
+		"is_closed BOOL NOT NULL, " +
+		"is_archived BOOL NOT NULL, " +
+		"is_pinned BOOL NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("topics table creation error: %v", err)
+	}
+
 	// Topic comments
-	_, err = mysqlDB.Exec("CREATE TABLE IF NOT EXISTS comments " +
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS comments " +
 		"(" +
 		"post_id INT PRIMARY KEY, " +
 		"category_slug TEXT NOT NULL, " +
@@ -67,6 +242,8 @@ func InitializeMySQLDatabase() error {
 		"update_time DATETIME NOT NULL, " +
 		"username VARCHAR(120) NOT NULL, " +
 		"is_initial_post BOOL NOT NULL, " +
+		"content MEDIUMTEXT, " +
+		"cooked_content MEDIUMTEXT, " +
 		"CONSTRAINT fk_username_comments FOREIGN KEY (username) REFERENCES users(username)" +
 		")")
 
@@ -74,14 +251,26 @@ func InitializeMySQLDatabase() error {
 		return fmt.Errorf("comments table creation error: %v", err)
 	}
 
+	// Post content columns for tables created by earlier versions
+	err = exporter.addColumnIfMissing("comments", "content", "MEDIUMTEXT")
+
+	if err == nil {
+		err = exporter.addColumnIfMissing("comments", "cooked_content", "MEDIUMTEXT")
+	}
+
+	if err != nil {
+		return fmt.Errorf("comments table update error: %v", err)
+	}
+
 	// Topic edits
-	_, err = mysqlDB.Exec("CREATE TABLE IF NOT EXISTS edits " +
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS edits " +
 		"(" +
 		"topic_id INT, " +
+		"post_id INT, " +
 		"edit_number INT, " +
 		"creation_time DATETIME NOT NULL, " +
 		"username VARCHAR(120) NOT NULL, " +
-		"primary key (topic_id, edit_number), " +
+		"primary key (topic_id, post_id, edit_number), " +
 		"CONSTRAINT fk_username_edits FOREIGN KEY (username) REFERENCES users(username)" +
 		")")
 
@@ -89,45 +278,301 @@ func InitializeMySQLDatabase() error {
 		return fmt.Errorf("edits table creation error: %v", err)
 	}
 
+	err = exporter.migrateEditsPostID()
+
+	if err != nil {
+		return fmt.Errorf("edits table update error: %v", err)
+	}
+
 	return nil
 }
 
-func ExportUsersMySQL(users []UserEntry) {
-	for _, user := range users {
-		_, err := mysqlDB.Exec("INSERT INTO users "+
-			"(user_id, username, name, primary_group_name) "+
-			"VALUES (?, ?, ?, ?) "+
-			"ON DUPLICATE KEY UPDATE "+
-			"username = VALUES(username), "+
-			"name = VALUES(name), "+
+// Edits tables created by earlier versions only held edits to each topic's main post, so assign those edits to it
+func (exporter *MySQLExporter) migrateEditsPostID() error {
+	exists, err := exporter.columnExists("edits", "post_id")
+
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = exporter.db.Exec("ALTER TABLE edits " +
+		"ADD COLUMN post_id INT NOT NULL DEFAULT 0 AFTER topic_id, " +
+		"DROP PRIMARY KEY, " +
+		"ADD PRIMARY KEY (topic_id, post_id, edit_number)")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = exporter.db.Exec("UPDATE edits " +
+		"JOIN comments ON comments.topic_id = edits.topic_id AND comments.is_initial_post " +
+		"SET edits.post_id = comments.post_id")
+	return err
+}
+
+func (exporter *MySQLExporter) columnExists(table string, column string) (bool, error) {
+	var columnCount int
+	err := exporter.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		table, column).Scan(&columnCount)
+
+	return columnCount > 0, err
+}
+
+func (exporter *MySQLExporter) addColumnIfMissing(table string, column string, definition string) error {
+	exists, err := exporter.columnExists(table, column)
+
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = exporter.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (exporter *MySQLExporter) ExportUsers(ctx context.Context, users []UserEntry) error {
+	return exporter.exportRows(ctx, mysqlBatchInsert{
+		dataset:    "users",
+		table:      "users",
+		columns:    []string{"user_id", "username", "name", "primary_group_name"},
+		keyColumns: []string{"user_id", "username"},
+		update: "username = VALUES(username), " +
+			"name = VALUES(name), " +
 			"primary_group_name = VALUES(primary_group_name)",
-			user.UserID, user.Username, user.Name, user.PrimaryGroupName)
+	}, len(users), func(i int) []any {
+		user := users[i]
+		return []any{user.UserID, user.Username, user.Name, user.PrimaryGroupName}
+	})
+}
+
+func (exporter *MySQLExporter) ExportCategories(ctx context.Context, categories []CategoryEntry) error {
+	return exporter.exportRows(ctx, mysqlBatchInsert{
+		dataset:    "categories",
+		table:      "categories",
+		columns:    []string{"category_id", "slug", "name", "parent_category_id", "description", "topic_count", "post_count", "color", "is_read_restricted"},
+		keyColumns: []string{"category_id"},
+		update: "slug = VALUES(slug), " +
+			"name = VALUES(name), " +
+			"parent_category_id = VALUES(parent_category_id), " +
+			"description = VALUES(description), " +
+			"topic_count = VALUES(topic_count), " +
+			"post_count = VALUES(post_count), " +
+			"color = VALUES(color), " +
+			"is_read_restricted = VALUES(is_read_restricted)",
+	}, len(categories), func(i int) []any {
+		category := categories[i]
+		return []any{category.CategoryID, category.Slug, category.Name, nullableInt(category.ParentCategoryID), nullableString(category.Description),
+			category.TopicCount, category.PostCount, category.Color, category.IsReadRestricted}
+	})
+}
+
+func (exporter *MySQLExporter) ExportTopics(ctx context.Context, topics []TopicEntry) error {
+	return exporter.exportRows(ctx, mysqlBatchInsert{
+		dataset:    "topics",
+		table:      "topics",
+		columns:    []string{"topic_id", "title", "slug", "category_slug", "category_id", "tags", "creation_time", "last_posted_time", "views", "like_count", "reply_count", "posters_count", "is_closed", "is_archived", "is_pinned"},
+		keyColumns: []string{"topic_id"},
+		update: "title = VALUES(title), " +
+			"slug = VALUES(slug), " +
+			"category_slug = VALUES(category_slug), " +
+			"category_id = VALUES(category_id), " +
+			"tags = VALUES(tags), " +
+			"last_posted_time = VALUES(last_posted_time), " +
+			"views = VALUES(views), " +
+			"like_count = VALUES(like_count), " +
+			"reply_count = VALUES(reply_count), " +
+			"posters_count = VALUES(posters_count), " +
+			"is_closed = VALUES(is_closed), " +
+			"is_archived = VALUES(is_archived), " +
+			"is_pinned = VALUES(is_pinned)",
+	}, len(topics), func(i int) []any {
+		topic := topics[i]
+		return []any{topic.TopicID, topic.Title, topic.Slug, topic.CategorySlug, topic.CategoryID, nullableString(strings.Join(topic.Tags, ",")),
+			topic.CreationTime, nullableTime(topic.LastPostedTime), topic.Views, topic.LikeCount, topic.ReplyCount, topic.PostersCount,
+			topic.IsClosed, topic.IsArchived, topic.IsPinned}
+	})
+}
+
+func (exporter *MySQLExporter) ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error {
+	return exporter.exportRows(ctx, mysqlBatchInsert{
+		dataset:    "posts",
+		table:      "comments",
+		columns:    []string{"category_slug", "topic_id", "post_id", "creation_time", "update_time", "username", "is_initial_post", "content", "cooked_content"},
+		keyColumns: []string{"post_id"},
+		update: "update_time = VALUES(update_time), " +
+			"content = COALESCE(VALUES(content), content), " +
+			"cooked_content = COALESCE(VALUES(cooked_content), cooked_content)",
+	}, len(topicComments), func(i int) []any {
+		topicComment := topicComments[i]
+		return []any{topicComment.CategorySlug, topicComment.TopicID, topicComment.PostID, topicComment.CreationTime, topicComment.UpdateTime, topicComment.Username, topicComment.IsInitialPost,
+			nullableString(topicComment.RawContent), nullableString(topicComment.CookedContent)}
+	})
+}
+
+func (exporter *MySQLExporter) ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error {
+	return exporter.exportRows(ctx, mysqlBatchInsert{
+		dataset: "edits",
+		table:   "edits",
+		columns: []string{"topic_id", "post_id", "edit_number", "creation_time", "username"},
+	}, len(topicEdits), func(i int) []any {
+		topicEdit := topicEdits[i]
+		return []any{topicEdit.TopicID, topicEdit.PostID, topicEdit.EditNumber, topicEdit.CreationTime, topicEdit.Username}
+	})
+}
+
+// Commit every dataset written since the last flush, or roll them all back if any of them failed
+func (exporter *MySQLExporter) Flush() error {
+	if exporter.txFailed {
+		exporter.txFailed = false
+		return fmt.Errorf("export rolled back after an error")
+	}
+
+	if exporter.tx == nil {
+		return nil
+	}
+
+	err := exporter.tx.Commit()
+	exporter.tx = nil
+
+	if err != nil {
+		return fmt.Errorf("transaction commit error: %v", err)
+	}
+
+	return nil
+}
+
+// Undo the current run's writes, keeping later datasets of the run from being written
+func (exporter *MySQLExporter) rollback() {
+	if exporter.tx != nil {
+		exporter.tx.Rollback()
+		exporter.tx = nil
+	}
+}
+
+// Write rows to a table in multi-row inserts within the run's transaction, rolling back the whole run on any error.
+// Logs how many rows were inserted, and how many were updated or, without an update clause, skipped.
+func (exporter *MySQLExporter) exportRows(ctx context.Context, batchInsert mysqlBatchInsert, total int, rowValues func(i int) []any) error {
+	if exporter.txFailed {
+		return fmt.Errorf("%s skipped, as the export was rolled back", batchInsert.dataset)
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	if exporter.tx == nil {
+		var err error
+		exporter.tx, err = exporter.db.Begin()
+
 		if err != nil {
-			log.Printf("ExportUsersMySQL error: %v", err)
+			exporter.txFailed = true
+			return fmt.Errorf("transaction start error: %v", err)
 		}
 	}
-}
 
-func ExportTopicCommentsMySQL(topicComments []TopicCommentsEntry) {
-	for _, topicComment := range topicComments {
-		_, err := mysqlDB.Exec("INSERT INTO comments "+
-			"(category_slug, topic_id, post_id, creation_time, update_time, username, is_initial_post) "+
-			"VALUES (?, ?, ?, ?, ?, ?, ?) "+
-			"ON DUPLICATE KEY UPDATE "+
-			"update_time = VALUES(update_time)",
-			topicComment.CategorySlug, topicComment.TopicID, topicComment.PostID, topicComment.CreationTime, topicComment.UpdateTime, topicComment.Username, topicComment.IsInitialPost)
+	// Keep each statement within the limit on placeholders
+	batchSize := min(exporter.batchSize, mysqlMaxPlaceholders/len(batchInsert.columns))
+	var inserted int64
+
+	for start := 0; start < total; start += batchSize {
+		if ctx.Err() != nil {
+			exporter.rollback()
+			exporter.txFailed = true
+			return fmt.Errorf("export stopped after %d of %d %s, rolled back", start, total, batchInsert.dataset)
+		}
+
+		end := min(start+batchSize, total)
+		batchInserted, err := batchInsert.write(exporter.tx, start, end, rowValues)
+
 		if err != nil {
-			log.Printf("ExportTopicCommentsMySQL error: %v", err)
+			exporter.rollback()
+			exporter.txFailed = true
+			return fmt.Errorf("rows %d to %d of %d %s could not be written, rolled back: %v", start+1, end, total, batchInsert.dataset, err)
 		}
+
+		inserted += batchInserted
 	}
+
+	if batchInsert.update == "" {
+		log.Printf("MySQL %s export: %d inserted, %d skipped", batchInsert.dataset, inserted, int64(total)-inserted)
+	} else {
+		log.Printf("MySQL %s export: %d inserted, %d updated", batchInsert.dataset, inserted, int64(total)-inserted)
+	}
+
+	return nil
 }
 
-func ExportTopicEditsMySQL(topicEdits []TopicEditsEntry) {
-	for _, topicEdit := range topicEdits {
-		_, err := mysqlDB.Exec("INSERT IGNORE INTO edits (topic_id, edit_number, creation_time, username) VALUES (?, ?, ?, ?)",
-			topicEdit.TopicID, topicEdit.EditNumber, topicEdit.CreationTime, topicEdit.Username)
-		if err != nil {
-			log.Printf("ExportTopicEditsMySQL error: %v", err)
+// Write rows start up to end, returning how many of them were inserted rather than updated or skipped
+func (batchInsert mysqlBatchInsert) write(tx *sql.Tx, start int, end int, rowValues func(i int) []any) (int64, error) {
+	// Skipped rows are not affected, so the affected rows are the inserted ones
+	if batchInsert.update == "" {
+		return batchInsert.exec(tx, start, end, rowValues)
+	}
+
+	// Updated rows count as one or two affected rows depending on whether they changed, so count them beforehand
+	existing, err := batchInsert.countExisting(tx, start, end, rowValues)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = batchInsert.exec(tx, start, end, rowValues)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(end-start) - existing, nil
+}
+
+// Count the rows start up to end that are already in the table
+func (batchInsert mysqlBatchInsert) countExisting(tx *sql.Tx, start int, end int, rowValues func(i int) []any) (int64, error) {
+	keyPlaceholders := "(" + strings.Repeat("?, ", end-start-1) + "?)"
+	conditions := make([]string, 0, len(batchInsert.keyColumns))
+	args := make([]any, 0, (end-start)*len(batchInsert.keyColumns))
+
+	for _, keyColumn := range batchInsert.keyColumns {
+		column := slices.Index(batchInsert.columns, keyColumn)
+		conditions = append(conditions, keyColumn+" IN "+keyPlaceholders)
+
+		for i := start; i < end; i++ {
+			args = append(args, rowValues(i)[column])
 		}
 	}
+
+	var existing int64
+	err := tx.QueryRow("SELECT COUNT(*) FROM "+batchInsert.table+" WHERE "+strings.Join(conditions, " OR "), args...).Scan(&existing)
+
+	// A row can match a different existing row on each key, but still only updates one of them
+	return min(existing, int64(end-start)), err
+}
+
+// Insert rows start up to end in one statement, returning the number of affected rows
+func (batchInsert mysqlBatchInsert) exec(tx *sql.Tx, start int, end int, rowValues func(i int) []any) (int64, error) {
+	rowPlaceholder := "(" + strings.Repeat("?, ", len(batchInsert.columns)-1) + "?)"
+	rowPlaceholders := make([]string, 0, end-start)
+	args := make([]any, 0, (end-start)*len(batchInsert.columns))
+
+	for i := start; i < end; i++ {
+		rowPlaceholders = append(rowPlaceholders, rowPlaceholder)
+		args = append(args, rowValues(i)...)
+	}
+
+	query := "INSERT INTO " + batchInsert.table + " (" + strings.Join(batchInsert.columns, ", ") + ") " +
+		"VALUES " + strings.Join(rowPlaceholders, ", ")
+
+	// Rows without an update clause are left as they are if they already exist
+	if batchInsert.update == "" {
+		query = "INSERT IGNORE" + strings.TrimPrefix(query, "INSERT")
+	} else {
+		query += " ON DUPLICATE KEY UPDATE " + batchInsert.update
+	}
+
+	result, err := tx.Exec(query, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type PostgresExporter struct {
+	serverURL string
+	username  string
+	password  string
+	sslMode   string
+
+	db *sql.DB
+}
+
+func init() {
+	RegisterExporter("postgres", NewPostgresExporter)
+}
+
+func NewPostgresExporter(config ExporterConfig) Exporter {
+	return &PostgresExporter{
+		serverURL: config.PostgresServerURL,
+		username:  config.PostgresUsername,
+		password:  config.PostgresPassword,
+		sslMode:   config.PostgresSSLMode,
+	}
+}
+
+func (exporter *PostgresExporter) Init() error {
+	err := exporter.connect()
+
+	if err != nil {
+		return err
+	}
+
+	return exporter.initializeDatabase()
+}
+
+func (exporter *PostgresExporter) RequiresUsers() bool {
+	return true
+}
+
+func (exporter *PostgresExporter) Close() error {
+	if exporter.db == nil {
+		return nil
+	}
+
+	return exporter.db.Close()
+}
+
+func (exporter *PostgresExporter) connect() error {
+	postgresURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(exporter.username, exporter.password),
+		Host:     exporter.serverURL,
+		Path:     "discourse",
+		RawQuery: url.Values{"sslmode": []string{exporter.sslMode}}.Encode(),
+	}
+
+	var err error
+	exporter.db, err = sql.Open("postgres", postgresURL.String())
+
+	if err != nil {
+		return fmt.Errorf("postgres connection setup error: %v", err)
+	}
+
+	exporter.db.SetConnMaxLifetime(time.Minute * 3)
+	exporter.db.SetMaxOpenConns(10)
+	exporter.db.SetMaxIdleConns(10)
+
+	err = exporter.db.Ping()
+
+	if err != nil {
+		return fmt.Errorf("postgres database ping error: %v", err)
+	}
+
+	return nil
+}
+
+func (exporter *PostgresExporter) initializeDatabase() error {
+	// Users
+	_, err := exporter.db.Exec("CREATE TABLE IF NOT EXISTS users " +
+		"(" +
+		"user_id INTEGER PRIMARY KEY, " +
+		"username VARCHAR(120) UNIQUE NOT NULL, " +
+		"name VARCHAR(120), " +
+		"primary_group_name VARCHAR(120)" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("users table creation error: %v", err)
+	}
+
+	// Categories
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS categories " +
+		"(" +
+		"category_id INTEGER PRIMARY KEY, " +
+		"slug VARCHAR(255) NOT NULL, " +
+		"name VARCHAR(120) NOT NULL, " +
+		"parent_category_id INTEGER, " +
+		"description TEXT, " +
+		"topic_count INTEGER NOT NULL, " +
+		"post_count INTEGER NOT NULL, " +
+		"color VARCHAR(10), " +
+		"is_read_restricted BOOLEAN NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("categories table creation error: %v", err)
+	}
+
+	// Topics
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS topics " +
+		"(" +
+		"topic_id INTEGER PRIMARY KEY, " +
+		"title TEXT NOT NULL, " +
+		"slug VARCHAR(255) NOT NULL, " +
+		"category_slug TEXT NOT NULL, " +
+		"category_id INTEGER NOT NULL, " +
+		"tags TEXT, " +
+		"creation_time TIMESTAMPTZ NOT NULL, " +
+		"last_posted_time TIMESTAMPTZ, " +
+		"views INTEGER NOT NULL, " +
+		"like_count INTEGER NOT NULL, " +
+		"reply_count INTEGER NOT NULL, " +
+		"posters_count INTEGER NOT NULL, " +
+		"is_closed BOOLEAN NOT NULL, " +
+		"is_archived BOOLEAN NOT NULL, " +
+		"is_pinned BOOLEAN NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("topics table creation error: %v", err)
+	}
+
+	// Topic comments
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS comments " +
+		"(" +
+		"post_id INTEGER PRIMARY KEY, " +
+		"category_slug TEXT NOT NULL, " +
+		"topic_id INTEGER NOT NULL, " +
+		"creation_time TIMESTAMPTZ NOT NULL, " +
+		"update_time TIMESTAMPTZ NOT NULL, " +
+		"username VARCHAR(120) NOT NULL, " +
+		"is_initial_post BOOLEAN NOT NULL, " +
+		"content TEXT, " +
+		"cooked_content TEXT, " +
+		"CONSTRAINT fk_username_comments FOREIGN KEY (username) REFERENCES users(username)" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("comments table creation error: %v", err)
+	}
+
+	// Post content columns for tables created by earlier versions
+	_, err = exporter.db.Exec("ALTER TABLE comments " +
+		"ADD COLUMN IF NOT EXISTS content TEXT, " +
+		"ADD COLUMN IF NOT EXISTS cooked_content TEXT")
+
+	if err != nil {
+		return fmt.Errorf("comments table update error: %v", err)
+	}
+
+	// Topic edits
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS edits " +
+		"(" +
+		"topic_id INTEGER, " +
+		"post_id INTEGER, " +
+		"edit_number INTEGER, " +
+		"creation_time TIMESTAMPTZ NOT NULL, " +
+		"username VARCHAR(120) NOT NULL, " +
+		"PRIMARY KEY (topic_id, post_id, edit_number), " +
+		"CONSTRAINT fk_username_edits FOREIGN KEY (username) REFERENCES users(username)" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("edits table creation error: %v", err)
+	}
+
+	err = exporter.migrateEditsPostID()
+
+	if err != nil {
+		return fmt.Errorf("edits table update error: %v", err)
+	}
+
+	return nil
+}
+
+// Edits tables created by earlier versions only held edits to each topic's main post, so assign those edits to it
+func (exporter *PostgresExporter) migrateEditsPostID() error {
+	var columnCount int
+	err := exporter.db.QueryRow("SELECT COUNT(*) FROM information_schema.columns " +
+		"WHERE table_schema = current_schema() AND table_name = 'edits' AND column_name = 'post_id'").Scan(&columnCount)
+
+	if err != nil || columnCount > 0 {
+		return err
+	}
+
+	tx, err := exporter.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("ALTER TABLE edits " +
+		"ADD COLUMN post_id INTEGER NOT NULL DEFAULT 0, " +
+		"DROP CONSTRAINT edits_pkey, " +
+		"ADD PRIMARY KEY (topic_id, post_id, edit_number)")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE edits SET post_id = comments.post_id " +
+		"FROM comments WHERE comments.topic_id = edits.topic_id AND comments.is_initial_post")
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (exporter *PostgresExporter) ExportUsers(ctx context.Context, users []UserEntry) error {
+	failed := 0
+
+	for i, user := range users {
+		if ctx.Err() != nil {
+			return exportStoppedError(i, len(users), "users")
+		}
+
+		_, err := exporter.db.Exec("INSERT INTO users "+
+			"(user_id, username, name, primary_group_name) "+
+			"VALUES ($1, $2, $3, $4) "+
+			"ON CONFLICT (user_id) DO UPDATE SET "+
+			"username = EXCLUDED.username, "+
+			"name = EXCLUDED.name, "+
+			"primary_group_name = EXCLUDED.primary_group_name",
+			user.UserID, user.Username, user.Name, user.PrimaryGroupName)
+		if err != nil {
+			log.Printf("PostgreSQL user export error: %v", err)
+			failed++
+		}
+	}
+
+	return exportFailureError(failed, len(users), "users")
+}
+
+func (exporter *PostgresExporter) ExportCategories(ctx context.Context, categories []CategoryEntry) error {
+	failed := 0
+
+	for i, category := range categories {
+		if ctx.Err() != nil {
+			return exportStoppedError(i, len(categories), "categories")
+		}
+
+		_, err := exporter.db.Exec("INSERT INTO categories "+
+			"(category_id, slug, name, parent_category_id, description, topic_count, post_count, color, is_read_restricted) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) "+
+			"ON CONFLICT (category_id) DO UPDATE SET "+
+			"slug = EXCLUDED.slug, "+
+			"name = EXCLUDED.name, "+
+			"parent_category_id = EXCLUDED.parent_category_id, "+
+			"description = EXCLUDED.description, "+
+			"topic_count = EXCLUDED.topic_count, "+
+			"post_count = EXCLUDED.post_count, "+
+			"color = EXCLUDED.color, "+
+			"is_read_restricted = EXCLUDED.is_read_restricted",
+			category.CategoryID, category.Slug, category.Name, nullableInt(category.ParentCategoryID), nullableString(category.Description),
+			category.TopicCount, category.PostCount, category.Color, category.IsReadRestricted)
+		if err != nil {
+			log.Printf("PostgreSQL category export error: %v", err)
+			failed++
+		}
+	}
+
+	return exportFailureError(failed, len(categories), "categories")
+}
+
+func (exporter *PostgresExporter) ExportTopics(ctx context.Context, topics []TopicEntry) error {
+	failed := 0
+
+	for i, topic := range topics {
+		if ctx.Err() != nil {
+			return exportStoppedError(i, len(topics), "topics")
+		}
+
+		_, err := exporter.db.Exec("INSERT INTO topics "+
+			"(topic_id, title, slug, category_slug, category_id, tags, creation_time, last_posted_time, views, like_count, reply_count, posters_count, is_closed, is_archived, is_pinned) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) "+
+			"ON CONFLICT (topic_id) DO UPDATE SET "+
+			"title = EXCLUDED.title, "+
+			"slug = EXCLUDED.slug, "+
+			"category_slug = EXCLUDED.category_slug, "+
+			"category_id = EXCLUDED.category_id, "+
+			"tags = EXCLUDED.tags, "+
+			"last_posted_time = EXCLUDED.last_posted_time, "+
+			"views = EXCLUDED.views, "+
+			"like_count = EXCLUDED.like_count, "+
+			"reply_count = EXCLUDED.reply_count, "+
+			"posters_count = EXCLUDED.posters_count, "+
+			"is_closed = EXCLUDED.is_closed, "+
+			"is_archived = EXCLUDED.is_archived, "+
+			"is_pinned = EXCLUDED.is_pinned",
+			topic.TopicID, topic.Title, topic.Slug, topic.CategorySlug, topic.CategoryID, nullableString(strings.Join(topic.Tags, ",")),
+			topic.CreationTime, nullableTime(topic.LastPostedTime), topic.Views, topic.LikeCount, topic.ReplyCount, topic.PostersCount,
+			topic.IsClosed, topic.IsArchived, topic.IsPinned)
+		if err != nil {
+			log.Printf("PostgreSQL topic export error: %v", err)
+			failed++
+		}
+	}
+
+	return exportFailureError(failed, len(topics), "topics")
+}
+
+func (exporter *PostgresExporter) ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error {
+	failed := 0
+
+	for i, topicComment := range topicComments {
+		if ctx.Err() != nil {
+			return exportStoppedError(i, len(topicComments), "posts")
+		}
+
+		_, err := exporter.db.Exec("INSERT INTO comments "+
+			"(category_slug, topic_id, post_id, creation_time, update_time, username, is_initial_post, content, cooked_content) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) "+
+			"ON CONFLICT (post_id) DO UPDATE SET "+
+			"update_time = EXCLUDED.update_time, "+
+			"content = COALESCE(EXCLUDED.content, comments.content), "+
+			"cooked_content = COALESCE(EXCLUDED.cooked_content, comments.cooked_content)",
+			topicComment.CategorySlug, topicComment.TopicID, topicComment.PostID, topicComment.CreationTime, topicComment.UpdateTime, topicComment.Username, topicComment.IsInitialPost,
+			nullableString(topicComment.RawContent), nullableString(topicComment.CookedContent))
+		if err != nil {
+			log.Printf("PostgreSQL post export error: %v", err)
+			failed++
+		}
+	}
+
+	return exportFailureError(failed, len(topicComments), "posts")
+}
+
+func (exporter *PostgresExporter) ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error {
+	failed := 0
+
+	for i, topicEdit := range topicEdits {
+		if ctx.Err() != nil {
+			return exportStoppedError(i, len(topicEdits), "edits")
+		}
+
+		_, err := exporter.db.Exec("INSERT INTO edits (topic_id, post_id, edit_number, creation_time, username) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING",
+			topicEdit.TopicID, topicEdit.PostID, topicEdit.EditNumber, topicEdit.CreationTime, topicEdit.Username)
+		if err != nil {
+			log.Printf("PostgreSQL edit export error: %v", err)
+			failed++
+		}
+	}
+
+	return exportFailureError(failed, len(topicEdits), "edits")
+}
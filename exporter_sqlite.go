@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Topic edits table layout, formatted with the table name so it can also be used when migrating
+const sqliteEditsTableFormat = "CREATE TABLE IF NOT EXISTS %s " +
+	"(" +
+	"topic_id INTEGER, " +
+	"post_id INTEGER, " +
+	"edit_number INTEGER, " +
+	"creation_time DATETIME NOT NULL, " +
+	"username TEXT NOT NULL, " +
+	"PRIMARY KEY (topic_id, post_id, edit_number), " +
+	"CONSTRAINT fk_username_edits FOREIGN KEY (username) REFERENCES users(username)" +
+	")"
+
+type SQLiteExporter struct {
+	path string
+
+	db *sql.DB
+}
+
+func init() {
+	RegisterExporter("sqlite", NewSQLiteExporter)
+}
+
+func NewSQLiteExporter(config ExporterConfig) Exporter {
+	return &SQLiteExporter{
+		path: config.SQLitePath,
+	}
+}
+
+func (exporter *SQLiteExporter) Init() error {
+	err := exporter.connect()
+
+	if err != nil {
+		return err
+	}
+
+	return exporter.initializeDatabase()
+}
+
+func (exporter *SQLiteExporter) RequiresUsers() bool {
+	return true
+}
+
+func (exporter *SQLiteExporter) Close() error {
+	if exporter.db == nil {
+		return nil
+	}
+
+	return exporter.db.Close()
+}
+
+func (exporter *SQLiteExporter) connect() error {
+	var err error
+	// Escape the path so characters such as ? and # are not read as part of the URI's query
+	exporter.db, err = sql.Open("sqlite3", "file:"+url.PathEscape(exporter.path)+"?_foreign_keys=on")
+
+	if err != nil {
+		return fmt.Errorf("sqlite database open error: %v", err)
+	}
+
+	// SQLite only allows a single writer at a time
+	exporter.db.SetMaxOpenConns(1)
+
+	err = exporter.db.Ping()
+
+	if err != nil {
+		return fmt.Errorf("sqlite database ping error: %v", err)
+	}
+
+	return nil
+}
+
+func (exporter *SQLiteExporter) initializeDatabase() error {
+	// Users
+	_, err := exporter.db.Exec("CREATE TABLE IF NOT EXISTS users " +
+		"(" +
+		"user_id INTEGER PRIMARY KEY, " +
+		"username TEXT UNIQUE NOT NULL, " +
+		"name TEXT, " +
+		"primary_group_name TEXT" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("users table creation error: %v", err)
+	}
+
+	// Categories
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS categories " +
+		"(" +
+		"category_id INTEGER PRIMARY KEY, " +
+		"slug TEXT NOT NULL, " +
+		"name TEXT NOT NULL, " +
+		"parent_category_id INTEGER, " +
+		"description TEXT, " +
+		"topic_count INTEGER NOT NULL, " +
+		"post_count INTEGER NOT NULL, " +
+		"color VARCHAR(10), " +
+		"is_read_restricted BOOLEAN NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("categories table creation error: %v", err)
+	}
+
+	// Topics
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS topics " +
+		"(" +
+		"topic_id INTEGER PRIMARY KEY, " +
+		"title TEXT NOT NULL, " +
+		"slug TEXT NOT NULL, " +
+		"category_slug TEXT NOT NULL, " +
+		"category_id INTEGER NOT NULL, " +
+		"tags TEXT, " +
+		"creation_time DATETIME NOT NULL, " +
+		"last_posted_time DATETIME, " +
+		"views INTEGER NOT NULL, " +
+		"like_count INTEGER NOT NULL, " +
+		"reply_count INTEGER NOT NULL, " +
+		"posters_count INTEGER NOT NULL, " +
+		"is_closed BOOLEAN NOT NULL, " +
+		"is_archived BOOLEAN NOT NULL, " +
+		"is_pinned BOOLEAN NOT NULL" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("topics table creation error: %v", err)
+	}
+
+	// Topic comments
+	_, err = exporter.db.Exec("CREATE TABLE IF NOT EXISTS comments " +
+		"(" +
+		"post_id INTEGER PRIMARY KEY, " +
+		"category_slug TEXT NOT NULL, " +
+		"topic_id INTEGER NOT NULL, " +
+		"creation_time DATETIME NOT NULL, " +
+		"update_time DATETIME NOT NULL, " +
+		"username TEXT NOT NULL, " +
+		"is_initial_post BOOLEAN NOT NULL, " +
+		"content TEXT, " +
+		"cooked_content TEXT, " +
+		"CONSTRAINT fk_username_comments FOREIGN KEY (username) REFERENCES users(username)" +
+		")")
+
+	if err != nil {
+		return fmt.Errorf("comments table creation error: %v", err)
+	}
+
+	// Post content columns for tables created by earlier versions
+	err = exporter.addColumnIfMissing("comments", "content", "TEXT")
+
+	if err == nil {
+		err = exporter.addColumnIfMissing("comments", "cooked_content", "TEXT")
+	}
+
+	if err != nil {
+		return fmt.Errorf("comments table update error: %v", err)
+	}
+
+	// Topic edits
+	_, err = exporter.db.Exec(fmt.Sprintf(sqliteEditsTableFormat, "edits"))
+
+	if err != nil {
+		return fmt.Errorf("edits table creation error: %v", err)
+	}
+
+	err = exporter.migrateEditsPostID()
+
+	if err != nil {
+		return fmt.Errorf("edits table update error: %v", err)
+	}
+
+	return nil
+}
+
+// Edits tables created by earlier versions only held edits to each topic's main post, so rebuild them with those
+// edits assigned to it, since SQLite cannot change the primary key of an existing table
+func (exporter *SQLiteExporter) migrateEditsPostID() error {
+	exists, err := exporter.columnExists("edits", "post_id")
+
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := exporter.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(sqliteEditsTableFormat, "edits_migration"))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO edits_migration (topic_id, post_id, edit_number, creation_time, username) " +
+		"SELECT edits.topic_id, COALESCE(comments.post_id, 0), edits.edit_number, edits.creation_time, edits.username " +
+		"FROM edits LEFT JOIN comments ON comments.topic_id = edits.topic_id AND comments.is_initial_post")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DROP TABLE edits")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("ALTER TABLE edits_migration RENAME TO edits")
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (exporter *SQLiteExporter) columnExists(table string, column string) (bool, error) {
+	var columnCount int
+	err := exporter.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&columnCount)
+
+	return columnCount > 0, err
+}
+
+func (exporter *SQLiteExporter) addColumnIfMissing(table string, column string, definition string) error {
+	exists, err := exporter.columnExists(table, column)
+
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = exporter.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (exporter *SQLiteExporter) ExportUsers(ctx context.Context, users []UserEntry) error {
+	return exporter.inTransaction(func(tx *sql.Tx) error {
+		failed := 0
+
+		for i, user := range users {
+			if ctx.Err() != nil {
+				return exportStoppedError(i, len(users), "users")
+			}
+
+			_, err := tx.Exec("INSERT INTO users "+
+				"(user_id, username, name, primary_group_name) "+
+				"VALUES (?, ?, ?, ?) "+
+				"ON CONFLICT (user_id) DO UPDATE SET "+
+				"username = excluded.username, "+
+				"name = excluded.name, "+
+				"primary_group_name = excluded.primary_group_name",
+				user.UserID, user.Username, user.Name, user.PrimaryGroupName)
+			if err != nil {
+				log.Printf("SQLite user export error: %v", err)
+				failed++
+			}
+		}
+
+		return exportFailureError(failed, len(users), "users")
+	})
+}
+
+func (exporter *SQLiteExporter) ExportCategories(ctx context.Context, categories []CategoryEntry) error {
+	return exporter.inTransaction(func(tx *sql.Tx) error {
+		failed := 0
+
+		for i, category := range categories {
+			if ctx.Err() != nil {
+				return exportStoppedError(i, len(categories), "categories")
+			}
+
+			_, err := tx.Exec("INSERT INTO categories "+
+				"(category_id, slug, name, parent_category_id, description, topic_count, post_count, color, is_read_restricted) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+				"ON CONFLICT (category_id) DO UPDATE SET "+
+				"slug = excluded.slug, "+
+				"name = excluded.name, "+
+				"parent_category_id = excluded.parent_category_id, "+
+				"description = excluded.description, "+
+				"topic_count = excluded.topic_count, "+
+				"post_count = excluded.post_count, "+
+				"color = excluded.color, "+
+				"is_read_restricted = excluded.is_read_restricted",
+				category.CategoryID, category.Slug, category.Name, nullableInt(category.ParentCategoryID), nullableString(category.Description),
+				category.TopicCount, category.PostCount, category.Color, category.IsReadRestricted)
+			if err != nil {
+				log.Printf("SQLite category export error: %v", err)
+				failed++
+			}
+		}
+
+		return exportFailureError(failed, len(categories), "categories")
+	})
+}
+
+func (exporter *SQLiteExporter) ExportTopics(ctx context.Context, topics []TopicEntry) error {
+	return exporter.inTransaction(func(tx *sql.Tx) error {
+		failed := 0
+
+		for i, topic := range topics {
+			if ctx.Err() != nil {
+				return exportStoppedError(i, len(topics), "topics")
+			}
+
+			_, err := tx.Exec("INSERT INTO topics "+
+				"(topic_id, title, slug, category_slug, category_id, tags, creation_time, last_posted_time, views, like_count, reply_count, posters_count, is_closed, is_archived, is_pinned) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+				"ON CONFLICT (topic_id) DO UPDATE SET "+
+				"title = excluded.title, "+
+				"slug = excluded.slug, "+
+				"category_slug = excluded.category_slug, "+
+				"category_id = excluded.category_id, "+
+				"tags = excluded.tags, "+
+				"last_posted_time = excluded.last_posted_time, "+
+				"views = excluded.views, "+
+				"like_count = excluded.like_count, "+
+				"reply_count = excluded.reply_count, "+
+				"posters_count = excluded.posters_count, "+
+				"is_closed = excluded.is_closed, "+
+				"is_archived = excluded.is_archived, "+
+				"is_pinned = excluded.is_pinned",
+				topic.TopicID, topic.Title, topic.Slug, topic.CategorySlug, topic.CategoryID, nullableString(strings.Join(topic.Tags, ",")),
+				topic.CreationTime, nullableTime(topic.LastPostedTime), topic.Views, topic.LikeCount, topic.ReplyCount, topic.PostersCount,
+				topic.IsClosed, topic.IsArchived, topic.IsPinned)
+			if err != nil {
+				log.Printf("SQLite topic export error: %v", err)
+				failed++
+			}
+		}
+
+		return exportFailureError(failed, len(topics), "topics")
+	})
+}
+
+func (exporter *SQLiteExporter) ExportPosts(ctx context.Context, topicComments []TopicCommentsEntry) error {
+	return exporter.inTransaction(func(tx *sql.Tx) error {
+		failed := 0
+
+		for i, topicComment := range topicComments {
+			if ctx.Err() != nil {
+				return exportStoppedError(i, len(topicComments), "posts")
+			}
+
+			_, err := tx.Exec("INSERT INTO comments "+
+				"(category_slug, topic_id, post_id, creation_time, update_time, username, is_initial_post, content, cooked_content) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+				"ON CONFLICT (post_id) DO UPDATE SET "+
+				"update_time = excluded.update_time, "+
+				"content = COALESCE(excluded.content, comments.content), "+
+				"cooked_content = COALESCE(excluded.cooked_content, comments.cooked_content)",
+				topicComment.CategorySlug, topicComment.TopicID, topicComment.PostID, topicComment.CreationTime, topicComment.UpdateTime, topicComment.Username, topicComment.IsInitialPost,
+				nullableString(topicComment.RawContent), nullableString(topicComment.CookedContent))
+			if err != nil {
+				log.Printf("SQLite post export error: %v", err)
+				failed++
+			}
+		}
+
+		return exportFailureError(failed, len(topicComments), "posts")
+	})
+}
+
+func (exporter *SQLiteExporter) ExportEdits(ctx context.Context, topicEdits []TopicEditsEntry) error {
+	return exporter.inTransaction(func(tx *sql.Tx) error {
+		failed := 0
+
+		for i, topicEdit := range topicEdits {
+			if ctx.Err() != nil {
+				return exportStoppedError(i, len(topicEdits), "edits")
+			}
+
+			_, err := tx.Exec("INSERT OR IGNORE INTO edits (topic_id, post_id, edit_number, creation_time, username) VALUES (?, ?, ?, ?, ?)",
+				topicEdit.TopicID, topicEdit.PostID, topicEdit.EditNumber, topicEdit.CreationTime, topicEdit.Username)
+			if err != nil {
+				log.Printf("SQLite edit export error: %v", err)
+				failed++
+			}
+		}
+
+		return exportFailureError(failed, len(topicEdits), "edits")
+	})
+}
+
+// Write a dataset in a single transaction, as SQLite otherwise commits and syncs every row on its own.
+// Rows written before an error or a stop are still committed.
+func (exporter *SQLiteExporter) inTransaction(write func(tx *sql.Tx) error) error {
+	tx, err := exporter.db.Begin()
+
+	if err != nil {
+		return fmt.Errorf("transaction start error: %v", err)
+	}
+
+	err = write(tx)
+	commitErr := tx.Commit()
+
+	if commitErr != nil {
+		return fmt.Errorf("transaction commit error: %v", commitErr)
+	}
+
+	return err
+}
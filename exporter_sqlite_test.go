@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteExporter(t *testing.T) {
+	// Characters that would otherwise end the path part of the database URI
+	path := filepath.Join(t.TempDir(), "discourse?#.db")
+
+	exporter := NewSQLiteExporter(ExporterConfig{SQLitePath: path}).(*SQLiteExporter)
+	err := exporter.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer exporter.Close()
+
+	ctx := context.Background()
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err = exporter.ExportUsers(ctx, []UserEntry{
+		{UserID: 1, Username: "alice", Name: "Alice"},
+		{UserID: 2, Username: "bob"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = exporter.ExportCategories(ctx, []CategoryEntry{{CategoryID: 3, Slug: "general", Name: "General", TopicCount: 1, PostCount: 2}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = exporter.ExportTopics(ctx, []TopicEntry{{TopicID: 10, Title: "Hello", Slug: "hello", CategorySlug: "general", CategoryID: 3, CreationTime: created}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []TopicCommentsEntry{
+		{CategorySlug: "general", TopicID: 10, PostID: 100, CreationTime: created, UpdateTime: created, Username: "alice", IsInitialPost: true, RawContent: "first"},
+		{CategorySlug: "general", TopicID: 10, PostID: 101, CreationTime: created, UpdateTime: created, Username: "bob"},
+	}
+
+	err = exporter.ExportPosts(ctx, posts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exporting again updates existing rows, keeping content that is no longer being exported
+	posts[0].RawContent = ""
+	posts[0].UpdateTime = created.Add(time.Hour)
+	err = exporter.ExportPosts(ctx, posts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edits := []TopicEditsEntry{{TopicID: 10, PostID: 100, EditNumber: 2, CreationTime: created, Username: "alice"}}
+
+	for range 2 {
+		err = exporter.ExportEdits(ctx, edits)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A post by an unknown user fails on its own without losing the rest of the dataset
+	err = exporter.ExportPosts(ctx, []TopicCommentsEntry{
+		{CategorySlug: "general", TopicID: 10, PostID: 102, CreationTime: created, UpdateTime: created, Username: "carol"},
+		{CategorySlug: "general", TopicID: 10, PostID: 103, CreationTime: created, UpdateTime: created, Username: "bob"},
+	})
+
+	if err == nil {
+		t.Error("expected an error exporting a post by an unknown user")
+	}
+
+	counts := map[string]int{"users": 2, "categories": 1, "topics": 1, "comments": 3, "edits": 1}
+
+	for table, expected := range counts {
+		var count int
+		err = exporter.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != expected {
+			t.Errorf("%s has %d rows, expected %d", table, count, expected)
+		}
+	}
+
+	var content string
+	var updateTime time.Time
+	err = exporter.db.QueryRow("SELECT content, update_time FROM comments WHERE post_id = 100").Scan(&content, &updateTime)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content != "first" || !updateTime.Equal(created.Add(time.Hour)) {
+		t.Errorf("post 100 has content %q and update time %v after being exported again", content, updateTime)
+	}
+}
+
+func TestSQLiteExporterStopped(t *testing.T) {
+	exporter := NewSQLiteExporter(ExporterConfig{SQLitePath: filepath.Join(t.TempDir(), "discourse.db")}).(*SQLiteExporter)
+	err := exporter.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer exporter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = exporter.ExportUsers(ctx, []UserEntry{{UserID: 1, Username: "alice"}})
+
+	if err == nil {
+		t.Error("expected an error exporting after being stopped")
+	}
+
+	var count int
+	err = exporter.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+
+	if err != nil || count != 0 {
+		t.Errorf("users has %d rows after a stopped export, expected 0 (error: %v)", count, err)
+	}
+}
@@ -2,38 +2,100 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/lvoytek/discourse_client_go/pkg/discourse"
+	"golang.org/x/term"
 )
 
+// Shared so input buffered while answering one prompt is kept for the next
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// An export flag to confirm with a prompt when it is not given
+type datasetOption struct {
+	flagName string
+	prompt   string
+	set      bool
+	value    *bool
+}
+
 func main() {
 	var (
-		exportPostsSet = false
-		exportEditsSet = false
-		exportUsersSet = false
-
-		discourseSiteURL       = kingpin.Flag("discourse.site-url", "The URL of the Discourse site to collect metrics from.").Default("http://127.0.0.1:3000").String()
-		discourseCategory      = kingpin.Flag("discourse.category", "Limit data collected to this category slug.").Default("").String()
-		discourseTopic         = kingpin.Flag("discourse.topic", "Limit data collected to this topic ID, overrides discourse.category.").Default("0").Int()
-		discourseRateLimit     = kingpin.Flag("discourse.rate-limit", "Time in seconds to delay each thread's call to Discourse site").Default("1").Int()
+		exportCategoriesSet   = false
+		exportTopicsSet       = false
+		exportPostsSet        = false
+		exportEditsSet        = false
+		exportUsersSet        = false
+		discourseRateLimitSet = false
+		nonInteractiveSet     = false
+
+		discourseSiteURL           = kingpin.Flag("discourse.site-url", "The URL of the Discourse site to collect metrics from.").Default("http://127.0.0.1:3000").String()
+		discourseAPIKey            = kingpin.Flag("discourse.api-key", "An API key to access the Discourse site with instead of browsing anonymously.").Envar("DISCOURSE_API_KEY").String()
+		discourseAPIKeyFile        = kingpin.Flag("discourse.api-key-file", "A file to read discourse.api-key from.").Envar("DISCOURSE_API_KEY_FILE").String()
+		discourseAPIUsername       = kingpin.Flag("discourse.api-username", "The Discourse user to make API calls as when using discourse.api-key.").Envar("DISCOURSE_API_USERNAME").String()
+		discourseCategories        = kingpin.Flag("discourse.category", "Limit data collected to this category slug, repeat to include several.").Strings()
+		discourseExcludeCategories = kingpin.Flag("discourse.exclude-category", "Leave out this category slug and its subcategories, repeat to exclude several.").Strings()
+		discourseTopics            = kingpin.Flag("discourse.topic", "Also collect this topic ID, repeat to include several. Without a category or tag, only the given topics are collected.").Ints()
+		discourseTags              = kingpin.Flag("discourse.tag", "Limit data collected to topics with this tag, repeat to include several. Combined with discourse.category, only tagged topics in those categories are collected.").Strings()
+		discourseRequestsPerSecond = kingpin.Flag("discourse.requests-per-second", "Maximum number of calls per second to make to the Discourse site, or 0 for no limit.").Default("1").Float64()
+		discourseBurst             = kingpin.Flag("discourse.burst", "Number of calls to the Discourse site that can be made at once before discourse.requests-per-second applies.").Default("1").Int()
+		discourseRetries           = kingpin.Flag("discourse.retries", "Number of times to retry a call to the Discourse site that fails to connect, returns an unreadable response, or is rejected with a 429 or 5xx status.").Default("3").Int()
+		discourseRetryBackoff      = kingpin.Flag("discourse.retry-backoff", "Time to wait before the first retry, doubling for each retry after it, unless the Discourse site gives a Retry-After time.").Default("2s").Duration()
+		discourseConcurrency       = kingpin.Flag("discourse.concurrency", "Number of categories, topics, or topic edit histories to collect at once.").Default("4").Int()
+		discourseRateLimit         = kingpin.Flag("discourse.rate-limit", "Deprecated, use discourse.requests-per-second. Time in seconds to wait between calls to the Discourse site.").PreAction(func(ctx *kingpin.ParseContext) error {
+			discourseRateLimitSet = true
+			return nil
+		}).Int()
 		dataRepeatCollect      = kingpin.Flag("data.repeat-collect", "Continue collecting data once every data.collection-interval time.").Default("false").Bool()
 		dataCollectionInterval = kingpin.Flag("data.collection-interval", "Time in minutes to wait before collecting new data from the Discourse site.").Default("60").Int()
-		exportType             = kingpin.Flag("data.export-type", "How to export the data: csv, json, or mysql").Default("json").String()
+		dataSince              = kingpin.Flag("data.since", "Only export topics, posts, and edits from this time on, given as an RFC3339 time, a date, or a time ago such as 30d.").String()
+		dataUntil              = kingpin.Flag("data.until", "Only export topics, posts, and edits from before this time, in the same formats as data.since.").String()
+		exportTypes            = kingpin.Flag("data.export-type", "How to export the data, repeat to export to several: "+strings.Join(ExporterNames(), ", ")).Default("json").Strings()
 		mysqlServerURL         = kingpin.Flag("mysql.database-url", "The location of the database to export to in mysql mode.").Default("localhost").String()
 		mysqlUsername          = kingpin.Flag("mysql.username", "The MySQL user to use for inputting data in mysql mode.").String()
-		mysqlPassword          = kingpin.Flag("mysql.password", "The password for the MySQL user to use in mysql mode.").String()
+		mysqlPassword          = kingpin.Flag("mysql.password", "The password for the MySQL user to use in mysql mode.").Envar("MYSQL_PASSWORD").String()
+		mysqlPasswordFile      = kingpin.Flag("mysql.password-file", "A file to read mysql.password from.").Envar("MYSQL_PASSWORD_FILE").String()
+		mysqlDatabase          = kingpin.Flag("mysql.database", "The name of the database to export to in mysql mode.").Default("discourse").String()
+		mysqlSocket            = kingpin.Flag("mysql.socket", "A unix socket to connect to the MySQL server through instead of mysql.database-url.").String()
+		mysqlTLSMode           = kingpin.Flag("mysql.tls", "Whether to connect to the MySQL server with TLS: false, true, skip-verify, or preferred").Default("false").Enum("false", "true", "skip-verify", "preferred")
+		mysqlTLSCAPath         = kingpin.Flag("mysql.tls-ca", "A PEM file of CA certificates to verify the MySQL server with when mysql.tls is true.").String()
+		mysqlDSN               = kingpin.Flag("mysql.dsn", "A full MySQL data source name to connect with instead of the other mysql connection options.").Envar("MYSQL_DSN").String()
+		mysqlDSNFile           = kingpin.Flag("mysql.dsn-file", "A file to read mysql.dsn from.").Envar("MYSQL_DSN_FILE").String()
+		mysqlMaxOpenConns      = kingpin.Flag("mysql.max-open-conns", "The most connections to open to the MySQL server at once.").Default("10").Int()
+		mysqlMaxIdleConns      = kingpin.Flag("mysql.max-idle-conns", "The most unused connections to keep open to the MySQL server.").Default("10").Int()
+		mysqlConnMaxLifetime   = kingpin.Flag("mysql.conn-max-lifetime", "How long to reuse a connection to the MySQL server for before replacing it.").Default("3m").Duration()
+		mysqlBatchSize         = kingpin.Flag("mysql.batch-size", "The most rows to write to MySQL in one statement.").Default("500").Int()
+		postgresServerURL      = kingpin.Flag("postgres.database-url", "The location of the database to export to in postgres mode.").Default("localhost").String()
+		postgresUsername       = kingpin.Flag("postgres.username", "The PostgreSQL user to use for inputting data in postgres mode.").String()
+		postgresPassword       = kingpin.Flag("postgres.password", "The password for the PostgreSQL user to use in postgres mode.").Envar("POSTGRES_PASSWORD").String()
+		postgresPasswordFile   = kingpin.Flag("postgres.password-file", "A file to read postgres.password from.").Envar("POSTGRES_PASSWORD_FILE").String()
+		postgresSSLMode        = kingpin.Flag("postgres.sslmode", "The SSL mode to connect to the database with in postgres mode: disable, require, verify-ca, or verify-full").Default("disable").Enum("disable", "require", "verify-ca", "verify-full")
+		sqlitePath             = kingpin.Flag("sqlite.path", "The database file to export to in sqlite mode.").Default("discourse.db").String()
 		csvFoldername          = kingpin.Flag("csv.foldername", "The name of the folder to send csv files to.").Default("out").String()
-		exportTopicComments    = kingpin.Flag("export.posts", "Export posts/comments for each topic.").PreAction(func(ctx *kingpin.ParseContext) error {
+		webListenAddress       = kingpin.Flag("web.listen-address", "Address to serve Prometheus metrics on at /metrics, such as :9101. Disabled when empty.").Default("").String()
+		cachePath              = kingpin.Flag("cache.path", "A file to load collected Discourse data from at startup and save it to after each collection.").Default("").String()
+		exportCategories       = kingpin.Flag("export.categories", "Export metadata for each category.").PreAction(func(ctx *kingpin.ParseContext) error {
+			exportCategoriesSet = true
+			return nil
+		}).Bool()
+		exportTopics = kingpin.Flag("export.topics", "Export metadata for each topic.").PreAction(func(ctx *kingpin.ParseContext) error {
+			exportTopicsSet = true
+			return nil
+		}).Bool()
+		exportTopicComments = kingpin.Flag("export.posts", "Export posts/comments for each topic.").PreAction(func(ctx *kingpin.ParseContext) error {
 			exportPostsSet = true
 			return nil
 		}).Bool()
-		exportTopicEdits = kingpin.Flag("export.edits", "Export edits to the main post for each topic.").PreAction(func(ctx *kingpin.ParseContext) error {
+		exportTopicEdits = kingpin.Flag("export.edits", "Export edits to posts in each topic, limited by export.edits-scope.").PreAction(func(ctx *kingpin.ParseContext) error {
 			exportEditsSet = true
 			return nil
 		}).Bool()
@@ -41,70 +103,398 @@ func main() {
 			exportUsersSet = true
 			return nil
 		}).Bool()
+		nonInteractive = kingpin.Flag("non-interactive", "Never prompt for missing options, skipping any dataset without an --export flag. Enabled automatically when stdin is not a terminal.").PreAction(func(ctx *kingpin.ParseContext) error {
+			nonInteractiveSet = true
+			return nil
+		}).Bool()
+		configPath        = kingpin.Flag("config", "A YAML file of option values to use unless given on the command line or through an environment variable.").String()
+		configProfile     = kingpin.Flag("profile", "A profile in the config file whose options replace those at its top level.").String()
+		exportEditsScope  = kingpin.Flag("export.edits-scope", "Which posts to export edits for: first (each topic's main post) or all").Default("first").Enum("first", "all")
+		exportPostContent = kingpin.Flag("export.post-content", "Include the content of each exported post: none, raw (markdown), cooked (HTML), or both").Default("none").Enum("none", "raw", "cooked", "both")
 	)
 
+	// Apply the config file before parsing, so options on the command line take precedence
+	if configArg := argValue(os.Args[1:], "config"); configArg != "" {
+		configuredFlags, err := LoadConfig(kingpin.CommandLine, configArg, argValue(os.Args[1:], "profile"))
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		exportCategoriesSet = configuredFlags["export.categories"]
+		exportTopicsSet = configuredFlags["export.topics"]
+		exportPostsSet = configuredFlags["export.posts"]
+		exportEditsSet = configuredFlags["export.edits"]
+		exportUsersSet = configuredFlags["export.users"]
+		discourseRateLimitSet = configuredFlags["discourse.rate-limit"]
+		nonInteractiveSet = configuredFlags["non-interactive"]
+	}
+
 	kingpin.Parse()
 
-	discourseClient := discourse.NewAnonymousClient(*discourseSiteURL)
+	if *configProfile != "" && *configPath == "" {
+		log.Fatal("profile requires a config file given with --config")
+	}
 
-	exporterErr := InitExporter(*exportType, *mysqlServerURL, *mysqlUsername, *mysqlPassword, *csvFoldername)
+	if *discourseBurst < 1 {
+		log.Fatal("discourse.burst must be at least 1")
+	}
+
+	if *discourseConcurrency < 1 {
+		log.Fatal("discourse.concurrency must be at least 1")
+	}
+
+	if *dataCollectionInterval < 1 {
+		log.Fatal("data.collection-interval must be at least 1")
+	}
+
+	if *mysqlBatchSize < 1 {
+		log.Fatal("mysql.batch-size must be at least 1")
+	}
+
+	rateLimitConfig := RateLimitConfig{
+		RequestsPerSecond: *discourseRequestsPerSecond,
+		Burst:             *discourseBurst,
+		Retries:           *discourseRetries,
+		RetryBackoff:      *discourseRetryBackoff,
+	}
+
+	if discourseRateLimitSet {
+		log.Println("discourse.rate-limit is deprecated, use discourse.requests-per-second instead")
+		rateLimitConfig.RequestsPerSecond = 0
+		rateLimitConfig.Burst = 1
+
+		if *discourseRateLimit > 0 {
+			rateLimitConfig.RequestsPerSecond = 1 / float64(*discourseRateLimit)
+		}
+	}
+
+	sinceBound, err := ParseTimeBound(*dataSince)
+
+	if err != nil {
+		log.Fatal("data.since: ", err)
+	}
+
+	untilBound, err := ParseTimeBound(*dataUntil)
+
+	if err != nil {
+		log.Fatal("data.until: ", err)
+	}
+
+	timeWindow := withTimeWindow(ItemsToExport{}, sinceBound, untilBound)
+
+	if !timeWindow.Since.IsZero() && !timeWindow.Until.IsZero() && !timeWindow.Since.Before(timeWindow.Until) {
+		log.Fatal("data.since must be before data.until")
+	}
+
+	collectorConfig := CollectorConfig{
+		Concurrency:  *discourseConcurrency,
+		Retries:      rateLimitConfig.Retries,
+		RetryBackoff: rateLimitConfig.RetryBackoff,
+	}
+
+	// Keep secrets out of the command line by reading them from files
+	secretFiles := []struct {
+		flagName string
+		path     string
+		value    *string
+	}{
+		{"discourse.api-key", *discourseAPIKeyFile, discourseAPIKey},
+		{"mysql.password", *mysqlPasswordFile, mysqlPassword},
+		{"mysql.dsn", *mysqlDSNFile, mysqlDSN},
+		{"postgres.password", *postgresPasswordFile, postgresPassword},
+	}
+
+	for _, secretFile := range secretFiles {
+		if secretFile.path == "" {
+			continue
+		}
+
+		if *secretFile.value != "" {
+			log.Fatalf("Only one of %s and %s-file can be given", secretFile.flagName, secretFile.flagName)
+		}
+
+		secret, err := readSecretFile(secretFile.path)
+
+		if err != nil {
+			log.Fatalf("%s-file: %v", secretFile.flagName, err)
+		}
+
+		*secretFile.value = secret
+	}
+
+	var discourseClient *discourse.Client
+
+	if *discourseAPIKey != "" || *discourseAPIUsername != "" {
+		if *discourseAPIKey == "" || *discourseAPIUsername == "" {
+			log.Fatal("Both discourse.api-key and discourse.api-username are required for authenticated access")
+		}
+
+		discourseClient = discourse.NewClient(*discourseSiteURL, *discourseAPIKey, *discourseAPIUsername)
+	} else {
+		discourseClient = discourse.NewAnonymousClient(*discourseSiteURL)
+	}
+
+	postRawContent := *exportPostContent == "raw" || *exportPostContent == "both"
+	postCookedContent := *exportPostContent == "cooked" || *exportPostContent == "both"
+
+	exporterConfig := ExporterConfig{
+		MySQLServerURL: *mysqlServerURL,
+		MySQLUsername:  *mysqlUsername,
+		MySQLPassword:  *mysqlPassword,
+		MySQLDatabase:  *mysqlDatabase,
+		MySQLSocket:    *mysqlSocket,
+		MySQLTLSMode:   *mysqlTLSMode,
+		MySQLTLSCAPath: *mysqlTLSCAPath,
+		MySQLDSN:       *mysqlDSN,
+
+		MySQLMaxOpenConns:    *mysqlMaxOpenConns,
+		MySQLMaxIdleConns:    *mysqlMaxIdleConns,
+		MySQLConnMaxLifetime: *mysqlConnMaxLifetime,
+		MySQLBatchSize:       *mysqlBatchSize,
+
+		PostgresServerURL: *postgresServerURL,
+		PostgresUsername:  *postgresUsername,
+		PostgresPassword:  *postgresPassword,
+		PostgresSSLMode:   *postgresSSLMode,
+
+		SQLitePath: *sqlitePath,
+
+		CSVFoldername: *csvFoldername,
+
+		PostRawContent:    postRawContent,
+		PostCookedContent: postCookedContent,
+	}
+
+	exporters, exporterErr := InitExporters(*exportTypes, exporterConfig)
 
 	if exporterErr != nil {
 		log.Fatal(exporterErr)
 	}
 
-	// Confirm user export for JSON and CSV
-	if !exportUsersSet && (*exportType == "csv" || *exportType == "json") {
-		*exportUsers = promptBool("Export user metadata")
+	defer CloseExporters(exporters)
+
+	if !nonInteractiveSet {
+		*nonInteractive = !stdinIsTerminal()
+	}
+
+	// Datasets to confirm when their export flag is not given
+	datasetOptions := []datasetOption{
+		{"export.categories", "Export metadata for each category", exportCategoriesSet, exportCategories},
+		{"export.topics", "Export metadata for each topic", exportTopicsSet, exportTopics},
+		{"export.posts", "Export posts/comments for each topic", exportPostsSet, exportTopicComments},
+		{"export.edits", "Export edits to posts in each topic", exportEditsSet, exportTopicEdits},
+	}
+
+	// Confirm user export for exporters that do not always include users
+	if slices.ContainsFunc(exporters, func(exporter ConfiguredExporter) bool { return !exporterRequiresUsers(exporter.Exporter) }) {
+		datasetOptions = slices.Insert(datasetOptions, 0, datasetOption{"export.users", "Export user metadata", exportUsersSet, exportUsers})
+	}
+
+	skippedDatasetFlags := []string{}
+	allDatasetFlags := []string{}
+
+	for _, option := range datasetOptions {
+		allDatasetFlags = append(allDatasetFlags, "--"+option.flagName)
+
+		if option.set {
+			continue
+		}
+
+		if *nonInteractive {
+			*option.value = false
+			skippedDatasetFlags = append(skippedDatasetFlags, "--"+option.flagName)
+		} else {
+			*option.value = promptBool(option.prompt, option.flagName)
+		}
+	}
+
+	if len(skippedDatasetFlags) > 0 {
+		log.Println("Running non-interactively, skipping datasets not chosen with:", strings.Join(skippedDatasetFlags, ", "))
 	}
 
-	// Confirm post and edit exports for all
-	if !exportPostsSet {
-		*exportTopicComments = promptBool("Export posts/comments for each topic")
+	if *nonInteractive && !slices.ContainsFunc(datasetOptions, func(option datasetOption) bool { return *option.value }) {
+		CloseExporters(exporters)
+		log.Fatal("Nothing to export, choose at least one dataset with: ", strings.Join(allDatasetFlags, ", "))
 	}
 
-	if !exportEditsSet {
-		*exportTopicEdits = promptBool("Export edits to the main post for each topic")
+	if *cachePath != "" {
+		cacheErr := LoadCache(*cachePath)
+
+		if cacheErr != nil {
+			log.Fatal(cacheErr)
+		}
 	}
 
 	itemsToExport := ItemsToExport{
+		Categories:    *exportCategories,
+		Topics:        *exportTopics,
 		TopicComments: *exportTopicComments,
 		TopicEdits:    *exportTopicEdits,
 		Users:         *exportUsers,
 
-		LimitToCategorySlug: *discourseCategory,
-		LimitToTopicID:      *discourseTopic,
+		PostRawContent:    postRawContent,
+		PostCookedContent: postCookedContent,
+		AllPostEdits:      *exportEditsScope == "all",
+
+		LimitToCategorySlugs: *discourseCategories,
+		LimitToTopicIDs:      *discourseTopics,
+		LimitToTags:          *discourseTags,
+		ExcludeCategorySlugs: *discourseExcludeCategories,
+	}
+
+	// Metrics describe a long-running collector, so are only served when collecting repeatedly
+	if *webListenAddress != "" && *dataRepeatCollect {
+		StartMetricsServer(*webListenAddress)
+	} else if *webListenAddress != "" {
+		log.Println("Not serving metrics, web.listen-address requires data.repeat-collect")
 	}
 
+	// Stop collecting and exporting on a stop request, letting the current write finish before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("Stop requested, finishing the current write before exiting - interrupt again to exit immediately")
+	}()
+
+	EnableRateLimiting(ctx, rateLimitConfig)
+
 	if *dataRepeatCollect {
-		for {
-			go IntervalCollectAndExport(discourseClient, *exportType, itemsToExport, time.Duration(*discourseRateLimit)*time.Second)
-			time.Sleep(time.Duration(*dataCollectionInterval) * time.Minute)
+		collectionInterval := time.Duration(*dataCollectionInterval) * time.Minute
+		ticker := time.NewTicker(collectionInterval)
+		defer ticker.Stop()
+
+		// Run one collection at a time, a run that overruns the interval is followed immediately by the next
+		for ctx.Err() == nil {
+			runDuration := IntervalCollectAndExport(ctx, discourseClient, exporters, withTimeWindow(itemsToExport, sinceBound, untilBound), collectorConfig, *cachePath)
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			if runDuration > collectionInterval {
+				log.Printf("Collection took longer than the %v collection interval, starting the next run now", collectionInterval)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
 		}
 	} else {
-		discourseData := Collect(discourseClient, itemsToExport, time.Duration(*discourseRateLimit)*time.Second)
-		ExportAll(discourseData, *exportType, itemsToExport)
+		itemsToExport = withTimeWindow(itemsToExport, sinceBound, untilBound)
+		discourseData, collectErr := Collect(ctx, discourseClient, itemsToExport, collectorConfig)
+		saveCacheIfEnabled(*cachePath)
+
+		if ctx.Err() != nil {
+			log.Println("Collection stopped, skipping export")
+			return
+		}
+
+		// Export everything that was collected before reporting skipped items
+		exportErr := ExportAll(ctx, discourseData, exporters, itemsToExport)
+
+		// A stop during the export is not a failure, anything left out is reported by the exporters
+		if ctx.Err() != nil {
+			log.Println("Export stopped")
+			return
+		}
+
+		if exportErr != nil {
+			CloseExporters(exporters)
+			log.Fatal(exportErr)
+		}
+
+		if collectErr != nil {
+			CloseExporters(exporters)
+			log.Fatal(collectErr)
+		}
+	}
+}
+
+func IntervalCollectAndExport(ctx context.Context, discourseClient *discourse.Client, exporters []ConfiguredExporter, itemsToExport ItemsToExport, collectorConfig CollectorConfig, cachePath string) time.Duration {
+	startTime := time.Now()
+	discourseData, collectErr := Collect(ctx, discourseClient, itemsToExport, collectorConfig)
+	saveCacheIfEnabled(cachePath)
+
+	if collectErr != nil {
+		log.Println(collectErr)
+	}
+
+	if ctx.Err() != nil {
+		log.Println("Collection stopped, skipping export")
+		return time.Since(startTime)
+	}
+
+	exportErr := ExportAll(ctx, discourseData, exporters, itemsToExport)
+
+	if exportErr != nil {
+		log.Println(exportErr)
+	}
+
+	runDuration := time.Since(startTime)
+	log.Printf("Collection and export finished in %v", runDuration.Round(time.Second))
+
+	return runDuration
+}
+
+// Resolve the export window for a collection starting now, so relative times move forward with each repeated collection
+func withTimeWindow(itemsToExport ItemsToExport, since TimeBound, until TimeBound) ItemsToExport {
+	now := time.Now()
+	itemsToExport.Since = since.At(now)
+	itemsToExport.Until = until.At(now)
+
+	return itemsToExport
+}
+
+func saveCacheIfEnabled(cachePath string) {
+	if cachePath == "" {
+		return
+	}
+
+	err := SaveCache(cachePath)
+
+	if err != nil {
+		log.Println("Unable to save cache -", err)
 	}
 }
 
-func IntervalCollectAndExport(discourseClient *discourse.Client, exportType string, itemsToExport ItemsToExport, rateLimit time.Duration) {
-	discourseData := Collect(discourseClient, itemsToExport, rateLimit)
-	ExportAll(discourseData, exportType, itemsToExport)
+// Ask a yes or no question on stdin until it is answered, exiting if stdin closes first
+func promptBool(prompt string, flagName string) bool {
+	for {
+		fmt.Printf("%s (y/n): ", prompt)
+		input, err := stdinReader.ReadString('\n')
+		input = strings.TrimSpace(strings.ToLower(input))
+
+		if input == "y" || input == "yes" {
+			return true
+		} else if input == "n" || input == "no" {
+			return false
+		}
+
+		if err != nil {
+			fmt.Println()
+			log.Fatalf("No answer given, set --%s or --no-%s, or run with --non-interactive", flagName, flagName)
+		}
+
+		fmt.Println("Please answer y or n")
+	}
 }
 
-func promptBool(prompt string) bool {
-	reader := bufio.NewReader(os.Stdin)
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
 
-	fmt.Printf("%s (y/n): ", prompt)
-	input, _ := reader.ReadString('\n')
-	input = strings.TrimSpace(strings.ToLower(input))
+// Read a secret from a file, ignoring the trailing newline most editors add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
 
-	if input == "y" || input == "yes" {
-		return true
-	} else if input == "n" || input == "no" {
-		return false
+	if err != nil {
+		return "", err
 	}
 
-	log.Fatal("Invalid input")
-	return false
+	return strings.TrimRight(string(data), "\r\n"), nil
 }
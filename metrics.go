@@ -11,15 +11,36 @@ type TopicCommentsEntry struct {
 	UpdateTime    time.Time `csv:"Last Update Time" json:"update_time,omitempty"`
 	Username      string    `csv:"Creator Username" json:"username"`
 	IsInitialPost bool      `csv:"Is the topic's main post" json:"is_initial_post"`
+	RawContent    string    `csv:"Raw Content" json:"raw,omitempty"`
+	CookedContent string    `csv:"Cooked Content" json:"cooked,omitempty"`
 }
 
 type TopicEditsEntry struct {
 	TopicID      int       `csv:"Topic ID" json:"topic_id"`
+	PostID       int       `csv:"Post ID" json:"post_id"`
 	EditNumber   int       `csv:"Edit Number" json:"edit_number"`
 	CreationTime time.Time `csv:"Creation Time" json:"creation_time"`
 	Username     string    `csv:"Editor Username" json:"username"`
 }
 
+type TopicEntry struct {
+	TopicID        int       `csv:"Topic ID" json:"topic_id"`
+	Title          string    `csv:"Title" json:"title"`
+	Slug           string    `csv:"Slug" json:"slug"`
+	CategorySlug   string    `csv:"Category Slug" json:"category_slug"`
+	CategoryID     int       `csv:"Category ID" json:"category_id"`
+	Tags           []string  `csv:"Tags" json:"tags,omitempty"`
+	CreationTime   time.Time `csv:"Creation Time" json:"creation_time"`
+	LastPostedTime time.Time `csv:"Last Posted Time" json:"last_posted_time"`
+	Views          int       `csv:"Views" json:"views"`
+	LikeCount      int       `csv:"Like Count" json:"like_count"`
+	ReplyCount     int       `csv:"Reply Count" json:"reply_count"`
+	PostersCount   int       `csv:"Posters Count" json:"posters_count"`
+	IsClosed       bool      `csv:"Is Closed" json:"is_closed"`
+	IsArchived     bool      `csv:"Is Archived" json:"is_archived"`
+	IsPinned       bool      `csv:"Is Pinned" json:"is_pinned"`
+}
+
 // Context Data
 type UserEntry struct {
 	UserID           int    `csv:"User ID" json:"user_id"`
@@ -28,19 +49,45 @@ type UserEntry struct {
 	PrimaryGroupName string `csv:"Primary Group Name" json:"primary_group_name,omitempty"`
 }
 
+type CategoryEntry struct {
+	CategoryID       int    `csv:"Category ID" json:"category_id"`
+	Slug             string `csv:"Slug" json:"slug"`
+	Name             string `csv:"Name" json:"name"`
+	ParentCategoryID int    `csv:"Parent Category ID" json:"parent_category_id,omitempty"`
+	Description      string `csv:"Description" json:"description,omitempty"`
+	TopicCount       int    `csv:"Topic Count" json:"topic_count"`
+	PostCount        int    `csv:"Post Count" json:"post_count"`
+	Color            string `csv:"Color" json:"color"`
+	IsReadRestricted bool   `csv:"Is Read Restricted" json:"is_read_restricted"`
+}
+
 // All output data
 type DataToExport struct {
-	Posts []TopicCommentsEntry `json:"posts,omitempty"`
-	Edits []TopicEditsEntry    `json:"edits,omitempty"`
-	Users []UserEntry          `json:"users,omitempty"`
+	Categories []CategoryEntry      `json:"categories,omitempty"`
+	Topics     []TopicEntry         `json:"topics,omitempty"`
+	Posts      []TopicCommentsEntry `json:"posts,omitempty"`
+	Edits      []TopicEditsEntry    `json:"edits,omitempty"`
+	Users      []UserEntry          `json:"users,omitempty"`
 }
 
 // Struct containing info on what types to export
 type ItemsToExport struct {
+	Categories    bool
+	Topics        bool
 	TopicComments bool
 	TopicEdits    bool
 	Users         bool
 
-	LimitToCategorySlug string
-	LimitToTopicID      int
+	PostRawContent    bool
+	PostCookedContent bool
+	AllPostEdits      bool
+
+	LimitToCategorySlugs []string
+	LimitToTopicIDs      []int
+	LimitToTags          []string
+	ExcludeCategorySlugs []string
+
+	// Export window, unbounded when zero
+	Since time.Time
+	Until time.Time
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Users who created a post within this long are counted as active
+const activeUserWindow = 30 * 24 * time.Hour
+
+var (
+	lastCollectionTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "discourse_last_collection_timestamp_seconds",
+		Help: "Unix time of the last collection from the Discourse site that completed without errors.",
+	})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "discourse_api_errors_total",
+		Help: "Number of failed Discourse API calls by the type of data requested.",
+	}, []string{"endpoint"})
+)
+
+// Prometheus collector deriving gauges from the current cache on each scrape
+type CacheCollector struct {
+	categoryPosts *prometheus.Desc
+	activeUsers   *prometheus.Desc
+	topicEdits    *prometheus.Desc
+}
+
+// Number of collected edits to a topic, along with the category it is stored under
+type topicEditCount struct {
+	categorySlug string
+	edits        int
+}
+
+func NewCacheCollector() *CacheCollector {
+	return &CacheCollector{
+		categoryPosts: prometheus.NewDesc("discourse_category_posts",
+			"Number of collected posts in each category.", []string{"category"}, nil),
+		activeUsers: prometheus.NewDesc("discourse_active_users",
+			"Number of users who created a collected post in the last 30 days.", nil, nil),
+		topicEdits: prometheus.NewDesc("discourse_topic_edits",
+			"Number of collected post edits in each topic.", []string{"topic_id", "category"}, nil),
+	}
+}
+
+func (collector *CacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.categoryPosts
+	ch <- collector.activeUsers
+	ch <- collector.topicEdits
+}
+
+func (collector *CacheCollector) Collect(ch chan<- prometheus.Metric) {
+	categoryPosts, activeUsers, topicEdits := cacheCounts()
+
+	for categorySlug, numPosts := range categoryPosts {
+		ch <- prometheus.MustNewConstMetric(collector.categoryPosts, prometheus.GaugeValue, float64(numPosts), categorySlug)
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.activeUsers, prometheus.GaugeValue, float64(activeUsers))
+
+	for topicID, editCount := range topicEdits {
+		ch <- prometheus.MustNewConstMetric(collector.topicEdits, prometheus.GaugeValue, float64(editCount.edits), strconv.Itoa(topicID), editCount.categorySlug)
+	}
+}
+
+// Count posts in each category, edits in each topic, and the active users, holding the cache lock only while counting
+func cacheCounts() (categoryPosts map[string]int, activeUsers int, topicEdits map[int]topicEditCount) {
+	cacheWriteMutex.Lock()
+	defer cacheWriteMutex.Unlock()
+
+	categoryPosts = map[string]int{}
+	topicCategories := map[int]string{}
+	activeUsernames := map[string]bool{}
+	activeSince := time.Now().Add(-activeUserWindow)
+
+	for categorySlug, topics := range cache.Topics {
+		categoryPosts[categorySlug] = 0
+
+		for topicID, topic := range topics {
+			categoryPosts[categorySlug] += len(topic.PostStream.Posts)
+			topicCategories[topicID] = categorySlug
+
+			for _, post := range topic.PostStream.Posts {
+				if post.CreatedAt.After(activeSince) {
+					activeUsernames[post.Username] = true
+				}
+			}
+		}
+	}
+
+	topicEdits = map[int]topicEditCount{}
+
+	for topicID, postRevisions := range cache.TopicEdits {
+		numEdits := 0
+
+		for _, revisions := range postRevisions {
+			numEdits += len(revisions)
+		}
+
+		topicEdits[topicID] = topicEditCount{categorySlug: topicCategories[topicID], edits: numEdits}
+	}
+
+	return categoryPosts, len(activeUsernames), topicEdits
+}
+
+// Serve Prometheus metrics on /metrics in the background
+func StartMetricsServer(listenAddress string) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCacheCollector(), lastCollectionTimestamp, apiErrors)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		err := http.ListenAndServe(listenAddress, mux)
+		log.Fatalln("Metrics server error -", err)
+	}()
+}
+
+func countAPIError(endpoint string) {
+	apiErrors.WithLabelValues(endpoint).Inc()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits on the calls made to the Discourse site
+type RateLimitConfig struct {
+	RequestsPerSecond float64
+	Burst             int
+	Retries           int
+	RetryBackoff      time.Duration
+}
+
+// Token bucket shared by every request to a single host, paused while the host asks clients to back off
+type hostRateLimit struct {
+	limiter *rate.Limiter
+
+	pauseMutex  sync.Mutex
+	pausedUntil time.Time
+}
+
+// HTTP transport that rate limits requests to each host and retries those rejected with a 429 or 5xx status
+type rateLimitedTransport struct {
+	base   http.RoundTripper
+	ctx    context.Context
+	config RateLimitConfig
+
+	hostsMutex sync.Mutex
+	hosts      map[string]*hostRateLimit
+}
+
+// Rate limit every request made through the default HTTP transport, which the Discourse client uses.
+// Waiting for the rate limit or a retry ends early once the context is cancelled.
+func EnableRateLimiting(ctx context.Context, config RateLimitConfig) {
+	http.DefaultTransport = &rateLimitedTransport{
+		base:   http.DefaultTransport,
+		ctx:    ctx,
+		config: config,
+		hosts:  map[string]*hostRateLimit{},
+	}
+}
+
+func (transport *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hostLimit := transport.hostRateLimit(req.URL.Host)
+
+	for attempt := 0; ; attempt++ {
+		err := hostLimit.wait(transport.ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+
+		// Each retry needs a fresh copy of the request body
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+
+			if req.GetBody != nil {
+				attemptReq.Body, err = req.GetBody()
+
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		res, err := transport.base.RoundTrip(attemptReq)
+
+		// Count every failed call, including those about to be retried
+		if (err != nil && transport.ctx.Err() == nil) || (err == nil && res.StatusCode >= http.StatusBadRequest) {
+			countAPIError(apiEndpoint(req.URL.Path))
+		}
+
+		if err != nil || !retryableStatus(res.StatusCode) || attempt >= transport.config.Retries {
+			return res, err
+		}
+
+		retryDelay := transport.config.RetryBackoff << attempt
+		retryAfter, hasRetryAfter := parseRetryAfter(res.Header.Get("Retry-After"))
+
+		if hasRetryAfter {
+			retryDelay = retryAfter
+		}
+
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		log.Printf("Discourse returned status %d for %s, retrying in %v", res.StatusCode, req.URL.Path, retryDelay)
+
+		// Hold back every request to a host that is rate limiting, but only this one after a server error
+		if res.StatusCode == http.StatusTooManyRequests {
+			hostLimit.pause(retryDelay)
+		} else {
+			err = sleepContext(transport.ctx, retryDelay)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (transport *rateLimitedTransport) hostRateLimit(host string) *hostRateLimit {
+	transport.hostsMutex.Lock()
+	defer transport.hostsMutex.Unlock()
+
+	hostLimit, ok := transport.hosts[host]
+
+	if !ok {
+		limit := rate.Limit(transport.config.RequestsPerSecond)
+
+		if transport.config.RequestsPerSecond <= 0 {
+			limit = rate.Inf
+		}
+
+		hostLimit = &hostRateLimit{limiter: rate.NewLimiter(limit, transport.config.Burst)}
+		transport.hosts[host] = hostLimit
+	}
+
+	return hostLimit
+}
+
+func (hostLimit *hostRateLimit) wait(ctx context.Context) error {
+	hostLimit.pauseMutex.Lock()
+	pauseRemaining := time.Until(hostLimit.pausedUntil)
+	hostLimit.pauseMutex.Unlock()
+
+	if pauseRemaining > 0 {
+		err := sleepContext(ctx, pauseRemaining)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return hostLimit.limiter.Wait(ctx)
+}
+
+func (hostLimit *hostRateLimit) pause(duration time.Duration) {
+	hostLimit.pauseMutex.Lock()
+	defer hostLimit.pauseMutex.Unlock()
+
+	pauseEnd := time.Now().Add(duration)
+
+	if pauseEnd.After(hostLimit.pausedUntil) {
+		hostLimit.pausedUntil = pauseEnd
+	}
+}
+
+// Find the type of data requested from a Discourse API path, such as topic for /t/123.json
+func apiEndpoint(path string) string {
+	segments := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), ".json"), "/")
+
+	switch segments[0] {
+	case "c", "categories":
+		return "category"
+	case "t":
+		return "topic"
+	case "u", "users":
+		return "user"
+	case "posts":
+		if slices.Contains(segments, "revisions") {
+			return "revision"
+		}
+
+		return "post"
+	}
+
+	return segments[0]
+}
+
+// Rate limiting and server errors are worth retrying, other failures will not change
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// Read a Retry-After header given as either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+
+	if err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	retryTime, err := http.ParseTime(value)
+
+	if err == nil {
+		return max(time.Until(retryTime), 0), true
+	}
+
+	return 0, false
+}
+
+// Wait for a duration, returning the context's error if it is cancelled first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
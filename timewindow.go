@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A point in time given on the command line, either fixed or relative to the start of each collection
+type TimeBound struct {
+	Time time.Time
+	Ago  time.Duration
+}
+
+// Parse an RFC3339 time, a date at midnight local time, or a time ago such as 30d, 2w, or 12h
+func ParseTimeBound(value string) (TimeBound, error) {
+	if value == "" {
+		return TimeBound{}, nil
+	}
+
+	fixedTime, err := time.Parse(time.RFC3339, value)
+
+	if err == nil {
+		return TimeBound{Time: fixedTime}, nil
+	}
+
+	fixedTime, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+
+	if err == nil {
+		return TimeBound{Time: fixedTime}, nil
+	}
+
+	var ago time.Duration
+
+	// Days and weeks are not supported by time.ParseDuration
+	if amount, found := strings.CutSuffix(value, "d"); found {
+		days, parseErr := strconv.Atoi(amount)
+		ago, err = time.Duration(days)*24*time.Hour, parseErr
+	} else if amount, found := strings.CutSuffix(value, "w"); found {
+		weeks, parseErr := strconv.Atoi(amount)
+		ago, err = time.Duration(weeks)*7*24*time.Hour, parseErr
+	} else {
+		ago, err = time.ParseDuration(value)
+	}
+
+	if err != nil || ago <= 0 {
+		return TimeBound{}, fmt.Errorf("invalid time %q, expected an RFC3339 time, a date such as 2024-06-01, or a time ago such as 30d", value)
+	}
+
+	return TimeBound{Ago: ago}, nil
+}
+
+// Get the time of the bound for a collection started at now, or the zero time if it is unset
+func (bound TimeBound) At(now time.Time) time.Time {
+	if bound.Ago > 0 {
+		return now.Add(-bound.Ago)
+	}
+
+	return bound.Time
+}
+
+// Check if a time is within the export window, from Since up to but not including Until
+func (itemsToExport ItemsToExport) InTimeWindow(t time.Time) bool {
+	return (itemsToExport.Since.IsZero() || !t.Before(itemsToExport.Since)) &&
+		(itemsToExport.Until.IsZero() || t.Before(itemsToExport.Until))
+}
+
+// Check if a topic had any activity within the export window
+func (itemsToExport ItemsToExport) TopicInTimeWindow(createdAt time.Time, lastActivityAt time.Time) bool {
+	return (itemsToExport.Since.IsZero() || !lastActivityAt.Before(itemsToExport.Since)) &&
+		(itemsToExport.Until.IsZero() || createdAt.Before(itemsToExport.Until))
+}